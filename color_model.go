@@ -0,0 +1,22 @@
+package paa
+
+import (
+	"image/color"
+	"math"
+)
+
+// grayLuma returns the Rec. 601 luma of c, as stored by the GRAYA encoder.
+func grayLuma(c color.NRGBA) uint8 {
+	return uint8(math.Round(0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)))
+}
+
+// colorModelFor returns the color model of the images decoded from paxType
+// mips: the padded file palette for P8 and NRGBA for everything else, GRAYA
+// included.
+func colorModelFor(paxType PaxType, palette []byte) color.Model {
+	if paxType == PaxP8 {
+		return indexedPalette(paletteColors(palette))
+	}
+
+	return color.NRGBAModel
+}
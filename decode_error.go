@@ -0,0 +1,130 @@
+package paa
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DecodeStage names the decode step in which a DecodeError happened.
+type DecodeStage int
+
+const (
+	// StageHeader is reading the pax type magic.
+	StageHeader DecodeStage = iota + 1
+	// StageTags is parsing GGAT tags.
+	StageTags
+	// StagePalette is reading the palette after the tags.
+	StagePalette
+	// StageSFFO is resolving mip offsets from the SFFO tag.
+	StageSFFO
+	// StageMip is reading a mip block header or its stored payload.
+	StageMip
+	// StageLZO is LZO decompression of a DXT mip.
+	StageLZO
+	// StageLZSS is LZSS decompression of a non-DXT mip.
+	StageLZSS
+	// StageBCn is decoding DXT/BCn blocks into pixels.
+	StageBCn
+	// StagePixels is converting uncompressed or indexed mip data into pixels.
+	StagePixels
+)
+
+// String returns a short name of the stage.
+func (s DecodeStage) String() string {
+	switch s {
+	case StageHeader:
+		return "header"
+	case StageTags:
+		return "tags"
+	case StagePalette:
+		return "palette"
+	case StageSFFO:
+		return "SFFO"
+	case StageMip:
+		return "mip block"
+	case StageLZO:
+		return "LZO"
+	case StageLZSS:
+		return "LZSS"
+	case StageBCn:
+		return "BCn"
+	case StagePixels:
+		return "pixels"
+	default:
+		return fmt.Sprintf("DecodeStage(%d)", int(s))
+	}
+}
+
+// DecodeError describes where a decode failed. It wraps the underlying error
+// (usually one of the package sentinels), so errors.Is and errors.As keep working.
+type DecodeError struct {
+	// Err is the underlying error.
+	Err error
+	// Offset is the file offset of the affected tag or mip block, -1 when unknown.
+	Offset int64
+	// Mip is the affected mip level, -1 when the error is not mip-specific.
+	Mip int
+	// Type is the pax type of the file, 0 when not known yet.
+	Type PaxType
+	// Stage is the decode step that failed.
+	Stage DecodeStage
+}
+
+// Error formats stage, mip, offset and pax type before the wrapped error.
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Stage.String())
+	if e.Mip >= 0 {
+		fmt.Fprintf(&b, " mip %d", e.Mip)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&b, " at offset %d", e.Offset)
+	}
+	if e.Type != 0 {
+		fmt.Fprintf(&b, " (%v)", e.Type)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+
+	return b.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError wraps err with stage context; nil stays nil.
+func decodeError(stage DecodeStage, typ PaxType, offset int64, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &DecodeError{Stage: stage, Type: typ, Offset: offset, Mip: -1, Err: err}
+}
+
+// mipError adds mip level and offset to err. An existing DecodeError keeps its
+// stage and only gets the missing fields filled; other errors get StageMip.
+func mipError(err error, typ PaxType, level int, offset int64) error {
+	if err == nil {
+		return nil
+	}
+
+	var de *DecodeError
+	if errors.As(err, &de) {
+		if de.Mip < 0 {
+			de.Mip = level
+		}
+		if de.Offset < 0 {
+			de.Offset = offset
+		}
+		if de.Type == 0 {
+			de.Type = typ
+		}
+
+		return err
+	}
+
+	return &DecodeError{Stage: StageMip, Type: typ, Offset: offset, Mip: level, Err: err}
+}
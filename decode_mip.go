@@ -0,0 +1,264 @@
+package paa
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/woozymasta/bcn"
+)
+
+// DecodeMip decodes a single mip level (0 is the largest) with the inverse of
+// the swizzle tag applied (see DecodeOptions.RawChannels).
+//
+// For seekable readers only the selected block is read and decompressed, located
+// via SFFO (or by walking mip headers when SFFO is unusable). Other readers are
+// read forward-only, skipping earlier blocks without decompressing them.
+func DecodeMip(r io.Reader, level int) (image.Image, error) {
+	return DecodeMipWithOptions(r, level, nil)
+}
+
+// DecodeMipWithOptions is DecodeMip with optional decode settings.
+func DecodeMipWithOptions(r io.Reader, level int, opts *DecodeOptions) (image.Image, error) {
+	if level < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrMipLevelOutOfRange, level)
+	}
+
+	return decodeSelectedMip(r, opts, nil, func(i int, _, _ uint16) bool {
+		return i == level
+	}, false)
+}
+
+// DecodeMipForSize decodes the largest mip level whose width and height are
+// both at most maxDim, or the smallest level when none fits. See DecodeMip.
+func DecodeMipForSize(r io.Reader, maxDim int) (image.Image, error) {
+	return DecodeMipForSizeWithOptions(r, maxDim, nil)
+}
+
+// DecodeMipForSizeWithOptions is DecodeMipForSize with optional decode settings.
+func DecodeMipForSizeWithOptions(r io.Reader, maxDim int, opts *DecodeOptions) (image.Image, error) {
+	if maxDim <= 0 {
+		return nil, ErrInvalidDimensions
+	}
+
+	return decodeSelectedMip(r, opts, nil, func(_ int, w, h uint16) bool {
+		return int(w) <= maxDim && int(h) <= maxDim
+	}, true)
+}
+
+// mipSelector reports whether mip i with the given dimensions should be decoded.
+// Mips are offered largest first; the first match wins.
+type mipSelector func(i int, w, h uint16) bool
+
+// decodeSelectedMip decodes the first mip accepted by pick. When none matches,
+// the smallest mip is used if fallbackLast is set, otherwise ErrMipLevelOutOfRange.
+// work, when not nil, is observed between reading and decoding the mip.
+func decodeSelectedMip(r io.Reader, opts *DecodeOptions, work *workState, pick mipSelector, fallbackLast bool) (image.Image, error) {
+	seeker, ok := r.(io.Seeker)
+	if !ok || (opts != nil && opts.Streaming) {
+		return decodeSelectedMipStream(r, opts, work, pick, fallbackLast)
+	}
+
+	lim := newDecodeLimits(opts)
+	m, err := decodeMetadata(r, seeker, lim)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.MipHeaders) == 0 {
+		return nil, ErrNoMipmaps
+	}
+
+	idx := -1
+	for i, mh := range m.MipHeaders {
+		if pick(i, mh.Width, mh.Height) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		if !fallbackLast {
+			return nil, fmt.Errorf("%w: file has %d mips", ErrMipLevelOutOfRange, len(m.MipHeaders))
+		}
+		idx = len(m.MipHeaders) - 1
+	}
+
+	offset := int64(m.MipHeaders[idx].Offset)
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		return nil, mipError(err, m.Type, idx, offset)
+	}
+
+	// Mip headers were already counted by decodeMetadata.
+	lim.mips = idx
+	if err := work.err(); err != nil {
+		return nil, err
+	}
+	mm, err := readMipMap(r, m.Type, lim)
+	if err != nil {
+		return nil, mipError(err, m.Type, idx, offset)
+	}
+	if mm == nil {
+		return nil, ErrNoMipmaps
+	}
+	work.report(ProgressRead, idx, 1)
+	if err := lim.image(mm); err != nil {
+		return nil, mipError(err, m.Type, idx, offset)
+	}
+
+	p := &PAA{Type: m.Type, Taggs: m.Taggs, Tags: m.Tags, Palette: m.Palette}
+	img, err := decodeMipImage(p, mm, opts, work, idx)
+	if werr := work.err(); werr != nil {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, mipError(err, m.Type, idx, offset)
+	}
+
+	return img, nil
+}
+
+// decodeSelectedMipStream is decodeSelectedMip for forward-only readers.
+// Mips before the selected one are read but not decompressed; with fallbackLast
+// the last stored block is kept until the next one is seen.
+func decodeSelectedMipStream(r io.Reader, opts *DecodeOptions, work *workState, pick mipSelector, fallbackLast bool) (image.Image, error) {
+	d, err := NewStreamDecoderWithOptions(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var last *storedMip
+	for i := 0; ; i++ {
+		if err := work.err(); err != nil {
+			return nil, err
+		}
+
+		b, err := d.nextStored()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if pick(i, b.width, b.height) {
+			last = b
+			break
+		}
+		if fallbackLast {
+			last = b
+		}
+	}
+
+	if last == nil {
+		if d.level == 0 {
+			return nil, ErrNoMipmaps
+		}
+
+		return nil, fmt.Errorf("%w: file has %d mips", ErrMipLevelOutOfRange, d.level)
+	}
+
+	mm, err := d.decompress(last)
+	if err != nil {
+		return nil, err
+	}
+	work.report(ProgressRead, d.level-1, 1)
+	if err := d.lim.image(mm); err != nil {
+		return nil, mipError(err, d.header.typ, d.level-1, d.lastOff)
+	}
+
+	img, err := decodeMipImage(d.file, mm, opts, work, d.level-1)
+	if werr := work.err(); werr != nil {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, mipError(err, d.header.typ, d.level-1, d.lastOff)
+	}
+
+	return img, nil
+}
+
+// decodeMipImage decodes mm, level mip of p, with the file palette, swizzle
+// and max color tags of p.
+func decodeMipImage(p *PAA, mm *MipMap, opts *DecodeOptions, work *workState, mip int) (image.Image, error) {
+	mm.file = p
+	return mm.imageWork(opts, work, mip)
+}
+
+// Images decodes every mip level of p, largest first, the same way DecodeMip
+// does: the palette and the inverse of the ZIWS swizzle come from p's tags
+// (see DecodeOptions.RawChannels).
+//
+// The worker budget is opts.BCn.Workers (0 or nil options use GOMAXPROCS, 1
+// decodes sequentially). As in encoding, a DXT base level is given to bcn with
+// the whole budget and the remaining levels are decoded concurrently by up to
+// budget goroutines, each passing bcn Workers 1. The mips in p are not
+// modified. On failure the error of the lowest failing level is returned.
+func (p *PAA) Images(opts *DecodeOptions) ([]image.Image, error) {
+	workers := 0
+	if opts != nil && opts.BCn != nil {
+		workers = opts.BCn.Workers
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	images := make([]image.Image, len(p.MipMaps))
+	errs := make([]error, len(p.MipMaps))
+
+	first := 0
+	if isDXT(p.Type) && workers > 1 && len(p.MipMaps) > 0 {
+		mm := *p.MipMaps[0]
+		mm.file = p
+		img, err := mm.ImageWithOptions(opts)
+		if err != nil {
+			return nil, mipError(err, p.Type, 0, -1)
+		}
+
+		images[0] = img
+		first = 1
+	}
+
+	// Concurrent levels run bcn on their own goroutine only.
+	levelOpts := &DecodeOptions{}
+	if opts != nil {
+		*levelOpts = *opts
+	}
+	levelBCn := &bcn.DecodeOptions{}
+	if levelOpts.BCn != nil {
+		*levelBCn = *levelOpts.BCn
+	}
+	levelBCn.Workers = 1
+	levelOpts.BCn = levelBCn
+
+	workers = min(workers, len(p.MipMaps)-first)
+	var next atomic.Int64
+	next.Store(int64(first))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(p.MipMaps) {
+					return
+				}
+
+				mm := *p.MipMaps[i]
+				mm.file = p
+				images[i], errs[i] = mm.ImageWithOptions(levelOpts)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, mipError(err, p.Type, i, -1)
+		}
+	}
+
+	return images, nil
+}
@@ -27,7 +27,12 @@ func decodePixelFormat(format PaxType, rawData []byte, width, height int, img *i
 		}
 
 	case PaxARGBA5:
-		for i := 0; i < len(rawData)/2; i++ {
+		totalPixels := w * h
+		if len(rawData) < totalPixels*2 {
+			return ErrInsufficientData
+		}
+
+		for i := 0; i < totalPixels; i++ {
 			p := binary.LittleEndian.Uint16(rawData[i*2:])
 			a := uint8(0)
 			if (p & 0x8000) != 0 {
@@ -46,7 +51,12 @@ func decodePixelFormat(format PaxType, rawData []byte, width, height int, img *i
 		}
 
 	case PaxARGB4:
-		for i := 0; i < len(rawData)/2; i++ {
+		totalPixels := w * h
+		if len(rawData) < totalPixels*2 {
+			return ErrInsufficientData
+		}
+
+		for i := 0; i < totalPixels; i++ {
 			p := binary.LittleEndian.Uint16(rawData[i*2:])
 			// 4-bit channels, masked so value fits in uint8
 			img.Pix[i*4+0] = uint8((p & 0x0F) << 4)         //nolint:gosec // G115
@@ -77,3 +87,26 @@ func decodePixelFormat(format PaxType, rawData []byte, width, height int, img *i
 
 	return nil
 }
+
+// unpremultiplyAlpha divides RGB by alpha in place (DXT2/DXT4 decode).
+// Fully transparent pixels keep their stored (zero) color.
+func unpremultiplyAlpha(img *image.NRGBA) {
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := y * img.Stride
+		for x := 0; x < img.Rect.Dx(); x++ {
+			off := row + x*4
+			a := uint16(img.Pix[off+3])
+			if a == 0 || a == 255 {
+				continue
+			}
+
+			for c := 0; c < 3; c++ {
+				v := (uint16(img.Pix[off+c])*255 + a/2) / a
+				if v > 255 {
+					v = 255
+				}
+				img.Pix[off+c] = uint8(v) //nolint:gosec // G115: clamped above
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package paa
+
+import (
+	"fmt"
+	"image"
+)
+
+// DitherMode selects how colors are reduced to the 4- and 5-bit channels of
+// ARGB4444 (PaxARGB4) and ARGB1555 (PaxARGBA5). Other pax types ignore it, as
+// does the 1-bit ARGB1555 alpha, which stays thresholded.
+type DitherMode int
+
+const (
+	// DitherDefault does not dither in EncodeWithOptions; EncodeOptionsFromHint
+	// replaces it with DitherFloydSteinberg when the hint enables dithering.
+	DitherDefault DitherMode = iota
+	// DitherNone truncates each channel (the low bits are dropped).
+	DitherNone
+	// DitherOrdered adds a 4x4 Bayer threshold pattern before quantizing.
+	// The pattern is stable across mips and frames and compresses well.
+	DitherOrdered
+	// DitherFloydSteinberg diffuses the quantization error to neighbouring
+	// pixels; smoothest gradients, but the noise pattern depends on content.
+	DitherFloydSteinberg
+)
+
+// String returns the mode name.
+func (m DitherMode) String() string {
+	switch m {
+	case DitherDefault:
+		return "default"
+	case DitherNone:
+		return "none"
+	case DitherOrdered:
+		return "ordered"
+	case DitherFloydSteinberg:
+		return "floyd-steinberg"
+	default:
+		return fmt.Sprintf("DitherMode(%d)", int(m))
+	}
+}
+
+// bayer4 is the 4x4 Bayer threshold matrix (values 0-15).
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// ditherSteps returns the quantization step per R, G, B, A channel for
+// format, matching the decoder (level << shift); 0 leaves a channel as is.
+// ARGB1555 alpha is a mask and stays thresholded by encodePixelFormat.
+func ditherSteps(format PaxType) ([4]int, bool) {
+	switch format {
+	case PaxARGB4:
+		return [4]int{16, 16, 16, 16}, true
+	case PaxARGBA5:
+		return [4]int{8, 8, 8, 0}, true
+	default:
+		return [4]int{}, false
+	}
+}
+
+// ditherImage returns img with every channel snapped to a level representable
+// in format, so that encodePixelFormat's truncation keeps the dithered value.
+// It returns img unchanged for other formats and for DitherDefault/DitherNone.
+func ditherImage(img image.Image, format PaxType, mode DitherMode) image.Image {
+	steps, ok := ditherSteps(format)
+	if !ok {
+		return img
+	}
+
+	switch mode {
+	case DitherOrdered:
+		return ditherOrdered(img, steps)
+	case DitherFloydSteinberg:
+		return ditherFloydSteinberg(img, steps)
+	default:
+		return img
+	}
+}
+
+// quantizeLevel returns the reconstruction of level floor(v/step), clamped to
+// the channel range.
+func quantizeLevel(v float64, step int) uint8 {
+	maxLevel := 256/step - 1
+	level := min(max(int(v/float64(step)), 0), maxLevel)
+
+	return uint8(level * step) //nolint:gosec // G115: <= 255
+}
+
+// ditherOrdered quantizes with a Bayer threshold: level = floor(v/step + t)
+// with t in (0, 1), so the average level over the pattern equals v/step.
+func ditherOrdered(img image.Image, steps [4]int) *image.NRGBA {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		so := src.PixOffset(b.Min.X, b.Min.Y+y)
+		do := dst.PixOffset(0, y)
+		for x := 0; x < b.Dx(); x++ {
+			t := (bayer4[y&3][x&3] + 0.5) / 16
+			for c, step := range steps {
+				v := src.Pix[so+x*4+c]
+				if step == 0 {
+					dst.Pix[do+x*4+c] = v
+					continue
+				}
+
+				dst.Pix[do+x*4+c] = quantizeLevel(float64(v)+t*float64(step), step)
+			}
+		}
+	}
+
+	return dst
+}
+
+// ditherFloydSteinberg quantizes to the nearest level and spreads the error
+// to the right (7/16) and to the next row (3/16, 5/16, 1/16).
+func ditherFloydSteinberg(img image.Image, steps [4]int) *image.NRGBA {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	// Error rows padded by one pixel on each side.
+	cur := make([]float64, (w+2)*4)
+	next := make([]float64, (w+2)*4)
+	for y := 0; y < h; y++ {
+		so := src.PixOffset(b.Min.X, b.Min.Y+y)
+		do := dst.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			e := (x + 1) * 4
+			for c, step := range steps {
+				v := src.Pix[so+x*4+c]
+				if step == 0 {
+					dst.Pix[do+x*4+c] = v
+					continue
+				}
+
+				// Clamp so error from clipped highlights does not pile up.
+				want := min(max(float64(v)+cur[e+c], 0), 255)
+				q := quantizeLevel(want+float64(step)/2, step)
+				dst.Pix[do+x*4+c] = q
+
+				diff := want - float64(q)
+				cur[e+4+c] += diff * 7 / 16
+				next[e-4+c] += diff * 3 / 16
+				next[e+c] += diff * 5 / 16
+				next[e+4+c] += diff * 1 / 16
+			}
+		}
+
+		cur, next = next, cur
+		clear(next)
+	}
+
+	return dst
+}
@@ -0,0 +1,81 @@
+package paa
+
+import (
+	"image"
+	"image/color"
+)
+
+// dynRangeMax returns the CXAM color (RGBA) for a texture expanded from a
+// level whose channel maxima are mx. Channels that are already full or empty
+// are not scaled and keep 255; alpha is never scaled.
+func dynRangeMax(mx [4]uint8) [4]uint8 {
+	out := [4]uint8{255, 255, 255, 255}
+	for c := 0; c < 3; c++ {
+		if mx[c] > 0 {
+			out[c] = mx[c]
+		}
+	}
+
+	return out
+}
+
+// expandDynRange stretches the RGB channels of img so that a value of
+// cxam[c] becomes 255. The engine multiplies sampled colors by CXAM, which
+// restores the original range with the full 8-bit (or DXT endpoint) precision.
+func expandDynRange(img image.Image, cxam [4]uint8) image.Image {
+	if cxam == [4]uint8{255, 255, 255, 255} {
+		return img
+	}
+
+	var lut [3][256]uint8
+	for c := 0; c < 3; c++ {
+		for v := range lut[c] {
+			lut[c][v] = uint8(min((v*255+int(cxam[c])/2)/int(cxam[c]), 255)) //nolint:gosec // G115: <= 255
+		}
+	}
+
+	src := toNRGBA(img)
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		so := src.PixOffset(b.Min.X, b.Min.Y+y)
+		do := dst.PixOffset(0, y)
+		for x := 0; x < b.Dx()*4; x += 4 {
+			dst.Pix[do+x+0] = lut[0][src.Pix[so+x+0]]
+			dst.Pix[do+x+1] = lut[1][src.Pix[so+x+1]]
+			dst.Pix[do+x+2] = lut[2][src.Pix[so+x+2]]
+			dst.Pix[do+x+3] = src.Pix[so+x+3]
+		}
+	}
+
+	return dst
+}
+
+// applyMaxColor multiplies the RGB channels of img by a CXAM tag (stored BGRA),
+// undoing expandDynRange. A missing or full-white tag returns img unchanged.
+func applyMaxColor(img image.Image, tag []byte) image.Image {
+	if len(tag) != 4 || (tag[0] == 255 && tag[1] == 255 && tag[2] == 255) {
+		return img
+	}
+
+	mx := color.NRGBA{R: tag[2], G: tag[1], B: tag[0]}
+	src := toNRGBA(img)
+	dst := image.NewNRGBA(image.Rect(0, 0, src.Rect.Dx(), src.Rect.Dy()))
+	for y := 0; y < src.Rect.Dy(); y++ {
+		so := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y)
+		do := dst.PixOffset(0, y)
+		for x := 0; x < src.Rect.Dx()*4; x += 4 {
+			dst.Pix[do+x+0] = scaleByte(src.Pix[so+x+0], mx.R)
+			dst.Pix[do+x+1] = scaleByte(src.Pix[so+x+1], mx.G)
+			dst.Pix[do+x+2] = scaleByte(src.Pix[so+x+2], mx.B)
+			dst.Pix[do+x+3] = src.Pix[so+x+3]
+		}
+	}
+
+	return dst
+}
+
+// scaleByte returns v*s/255 rounded.
+func scaleByte(v, s uint8) uint8 {
+	return uint8((uint16(v)*uint16(s) + 127) / 255) //nolint:gosec // G115: <= 255
+}
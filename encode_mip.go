@@ -0,0 +1,141 @@
+package paa
+
+import (
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/woozymasta/bcn"
+	"github.com/woozymasta/paa/texconfig"
+)
+
+// mipEncoder holds the settings shared by the levels of one encode.
+type mipEncoder struct {
+	opts    *EncodeOptions
+	bcnOpts *bcn.EncodeOptions
+	prog    *stageProgress
+	// palette is the P8 palette built from the base level.
+	palette color.Palette
+	paxType PaxType
+}
+
+// encodeLevels swizzles, compresses and stores every level, largest first.
+//
+// The worker budget is BCn.Workers (0 or nil options use GOMAXPROCS, 1 encodes
+// serially). A DXT base level, three quarters of the chain's pixels, is given
+// to bcn with the whole budget; the remaining levels are encoded concurrently
+// by up to budget goroutines, each passing bcn Workers 1 so that the budget is
+// not multiplied. Levels are independent, so the output does not depend on the
+// scheduling. On failure the error of the lowest failing level is returned.
+func (e *mipEncoder) encodeLevels(mipImages []image.Image) ([]mipBlock, error) {
+	workers := 0
+	if e.bcnOpts != nil {
+		workers = e.bcnOpts.Workers
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	blocks := make([]mipBlock, len(mipImages))
+
+	first := 0
+	if isDXT(e.paxType) && workers > 1 && len(mipImages) > 0 {
+		mb, err := e.level(0, mipImages[0], e.bcnOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks[0] = mb
+		first = 1
+	}
+
+	// Concurrent levels run bcn on their own goroutine only.
+	levelOpts := &bcn.EncodeOptions{}
+	if e.bcnOpts != nil {
+		*levelOpts = *e.bcnOpts
+	}
+	levelOpts.Workers = 1
+
+	rest := mipImages[first:]
+	errs := make([]error, len(rest))
+	workers = min(workers, len(rest))
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(rest) {
+					return
+				}
+
+				blocks[first+i], errs[i] = e.level(first+i, rest[i], levelOpts)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return blocks, nil
+}
+
+// prepare applies the normal map and channel swizzles requested by the options.
+func (e *mipEncoder) prepare(m image.Image) image.Image {
+	opts := e.opts
+	if opts != nil && opts.NormalMapSwizzle {
+		m = swizzleNormalMap(m)
+	}
+	if opts != nil && opts.Swizzle != nil && !opts.SkipSwizzle {
+		m = texconfig.ApplyChannelSwizzle(m, *opts.Swizzle)
+	}
+
+	return m
+}
+
+// level swizzles, compresses and stores mip i, passing bcnOpts to bcn.
+func (e *mipEncoder) level(i int, m image.Image, bcnOpts *bcn.EncodeOptions) (mipBlock, error) {
+	if err := e.prog.work.err(); err != nil {
+		return mipBlock{}, err
+	}
+
+	encodeImg := e.prepare(m)
+
+	var payload []byte
+	var err error
+	switch {
+	case isDXT(e.paxType):
+		// DXT2/DXT4 store colors premultiplied by alpha (DXT3/DXT5 blocks).
+		blockImg := encodeImg
+		if isPremultipliedDXT(e.paxType) {
+			blockImg = premultiplyAlpha(encodeImg)
+		}
+		payload, err = encodeBCn(blockImg, e.paxType, bcnOpts, e.prog, i)
+	case e.paxType == PaxP8:
+		payload, err = encodePaletted(encodeImg, e.palette)
+	default:
+		if e.opts != nil {
+			encodeImg = ditherImage(encodeImg, e.paxType, e.opts.Dither)
+		}
+		payload, err = encodePixelFormat(e.paxType, encodeImg)
+	}
+	if err != nil {
+		return mipBlock{}, err
+	}
+
+	b := encodeImg.Bounds()
+	if !isDXT(e.paxType) {
+		e.prog.add(i, b.Dx()*b.Dy())
+	}
+
+	return storeMip(payload, b.Dx(), b.Dy(), e.paxType, e.opts)
+}
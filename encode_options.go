@@ -9,19 +9,44 @@ import (
 // Used by EncodeWithOptions and EncodeOptionsFromHint.
 type EncodeOptions struct {
 	// BCn overrides DXT/BCn encoding options (quality, refinement, workers).
-	// Nil uses bcn defaults.
+	// Nil uses bcn defaults. BCn.Workers also bounds how many mip levels are
+	// encoded concurrently (0 uses GOMAXPROCS, 1 encodes them one by one).
 	BCn *bcn.EncodeOptions
 	// Swizzle applies a generic channel swizzle before encoding (TexConvert.cfg style).
 	Swizzle *texconfig.ChannelSwizzle
 	// GenerateMipmaps controls mipmap generation. Nil = default (true).
 	GenerateMipmaps *bool
 	// MipmapFilter selects a specific mipmap filter (TexConvert.cfg).
+	// It is applied after MipKernel has built each level.
 	MipmapFilter *texconfig.MipmapFilter
+	// Progress receives progress reports from EncodeContext (and the encode
+	// functions built on it). Nil reports nothing.
+	Progress ProgressFunc
+	// ExtraTags are written after the generated tags and before SFFO, in order;
+	// tags sharing a name are all written. The first tag named like a generated
+	// one (CGVA, CXAM, GALF, ZIWS) replaces its payload instead; SFFO is ignored.
+	ExtraTags []Tag
 	// MaxMipCount limits the number of mip levels (including base). 0 = no limit.
 	MaxMipCount int
 	// MinMipSize stops mip generation when both dimensions are <= this value. 0 = default (4).
 	MinMipSize int
+	// PowerOfTwo selects how a base image whose width or height is not a power
+	// of two is handled. The zero value allows any size in EncodeWithOptions;
+	// EncodeWithTexConfig rejects such images unless overridden.
+	PowerOfTwo PowerOfTwoPolicy
+	// ResampleFilter is the kernel used by the PowerOfTwo scale policies.
+	// Zero uses Lanczos.
+	ResampleFilter ResampleFilter
+	// Dither selects dithering for ARGB4444/ARGB1555 payloads.
+	// Zero truncates like DitherNone. ARGB1555 alpha is not dithered: the
+	// 1-bit alpha is a cutout mask, thresholded at 128, and dithering it
+	// would speckle the edges.
+	Dither DitherMode
+	// MipKernel is the downsampling kernel for generated mips; each level is
+	// filtered from the previous one. Zero keeps the 2x2 box average.
+	MipKernel ResampleFilter
 	// Type is the PAA pixel format (PaxDXT1, PaxDXT5, etc.).
+	// DXT2/DXT4 premultiply RGB by alpha before compression (decode reverses it).
 	// Zero value means auto: DXT5 if image has any non-opaque alpha, else DXT1.
 	Type PaxType
 	// SwizzleTag is the SWIZTAGG payload to write when WriteSwizzleTag is true.
@@ -39,8 +64,13 @@ type EncodeOptions struct {
 	SkipSwizzle bool
 	// WriteGALF writes a GALF tag. If false and opts is provided, no GALF is written.
 	WriteGALF bool
-	// GALFValue is the GALF payload byte (typically 1; detail maps use 2).
+	// GALFValue is the GALF payload byte (typically AlphaInterpolated; detail maps use AlphaBinary).
 	GALFValue byte
+	// AlphaCoverageRef, when non-zero, rescales the alpha of each generated mip
+	// so that the fraction of pixels with alpha above this reference matches
+	// the base level (e.g. 128 for a 0.5 alpha test). This keeps alpha-tested
+	// foliage and fences from thinning out at distance.
+	AlphaCoverageRef uint8
 	// ForceCXAMFull, when true, writes CXAM as 0xFF in all channels regardless of actual max.
 	// This matches BI tools for DXT payloads and aligns TexView "max color" stats.
 	ForceCXAMFull bool
@@ -48,16 +78,53 @@ type EncodeOptions struct {
 	UseLZO bool
 	// ForceLZSS forces LZSS compression for non-DXT payloads, even when it grows size.
 	ForceLZSS bool
-	// UseSRGB enables sRGB-aware downscale for mip generation.
+	// ExpandDynRange stretches each color channel so that the base level's
+	// maximum becomes 255 and writes those maxima to CXAM, which the engine
+	// multiplies back in (TexConvert dynRange). CGVA describes the stored,
+	// expanded colors. Dark textures keep more precision this way.
+	ExpandDynRange bool
+	// UseSRGB enables sRGB-aware (gamma-correct) downscale for mip generation:
+	// RGB is filtered in linear light.
 	UseSRGB bool
+	// WrapEdges treats the image as tileable: MipKernel and the PowerOfTwo
+	// scale policies sample across the opposite edge instead of repeating
+	// the border pixels. Without MipKernel it selects the box kernel.
+	WrapEdges bool
 }
 
 // DecodeOptions configures PAA decoding.
 // BCn options are forwarded to the BCn decoder (e.g. workers).
+// Limits fail the decode with ErrLimitExceeded; use them for untrusted input.
 type DecodeOptions struct {
 	// BCn overrides DXT/BCn decoding options (workers).
 	// Nil uses bcn defaults.
 	BCn *bcn.DecodeOptions
+	// Progress receives progress reports from DecodeContext and
+	// DecodePAAContext. Nil reports nothing.
+	Progress ProgressFunc
+	// MaxTotalBytes limits memory held during one decode: tags, stored and
+	// decompressed mip payloads, the buffered input when a non-seekable reader
+	// has to be read into memory, and the image returned by the Decode and
+	// DecodeMip functions (4 bytes per pixel, 1 for P8). Zero means no limit.
+	MaxTotalBytes int64
+	// MaxWidth and MaxHeight reject mips larger than this. Zero means no limit.
+	MaxWidth  int
+	MaxHeight int
+	// MaxTagSize limits a single GGAT tag payload.
+	// Zero uses DefaultMaxTagSize.
+	MaxTagSize int
+	// MaxMipCount limits the number of mip levels read. Zero means no limit.
+	MaxMipCount int
+	// Streaming forces forward-only decoding (see StreamDecoder) even for
+	// seekable readers. Non-seekable readers are always streamed by Decode.
+	Streaming bool
+	// RawChannels returns pixels with channels as stored in the file, without
+	// inverting the ZIWS swizzle or applying the max color.
+	RawChannels bool
+	// ApplyMaxColor multiplies RGB by the CXAM tag, restoring the original
+	// colors of textures encoded with EncodeOptions.ExpandDynRange. Files whose
+	// CXAM is the plain channel maximum come out darker with it, so it is off by default.
+	ApplyMaxColor bool
 }
 
 // Note: filename-based resolution is provided by the texconfig package.
@@ -4,7 +4,6 @@ import (
 	"encoding/binary"
 	"image"
 	"image/color"
-	"math"
 )
 
 // encodePixelFormat encodes img into raw uncompressed pixel data.
@@ -19,71 +18,81 @@ func encodePixelFormat(format PaxType, img image.Image) ([]byte, error) {
 	switch format {
 	case PaxARGB8:
 		raw := make([]byte, w*h*4)
-		i := 0
-		for y := b.Min.Y; y < b.Max.Y; y++ {
-			for x := b.Min.X; x < b.Max.X; x++ {
-				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
-				raw[i+0] = c.B // store as BGRA
-				raw[i+1] = c.G
-				raw[i+2] = c.R
-				raw[i+3] = c.A
-				i += 4
+		nrgbaRows(img, func(y int, row []uint8) {
+			out := raw[y*w*4 : (y+1)*w*4]
+			for i := 0; i < len(row); i += 4 {
+				out[i+0] = row[i+2] // store as BGRA
+				out[i+1] = row[i+1]
+				out[i+2] = row[i+0]
+				out[i+3] = row[i+3]
 			}
-		}
+		})
 		return raw, nil
 
 	case PaxARGBA5:
 		raw := make([]byte, w*h*2)
-		i := 0
-		for y := b.Min.Y; y < b.Max.Y; y++ {
-			for x := b.Min.X; x < b.Max.X; x++ {
-				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		nrgbaRows(img, func(y int, row []uint8) {
+			out := raw[y*w*2 : (y+1)*w*2]
+			for i, o := 0, 0; i < len(row); i, o = i+4, o+2 {
 				a := uint16(0)
-				if c.A >= 128 {
+				if row[i+3] >= 128 {
 					a = 1
 				}
-				r := uint16(c.R >> 3)
-				g := uint16(c.G >> 3)
-				bl := uint16(c.B >> 3)
+				r := uint16(row[i+0] >> 3)
+				g := uint16(row[i+1] >> 3)
+				bl := uint16(row[i+2] >> 3)
 				p := (a << 15) | (r << 10) | (g << 5) | bl
-				binary.LittleEndian.PutUint16(raw[i:], p)
-				i += 2
+				binary.LittleEndian.PutUint16(out[o:], p)
 			}
-		}
+		})
 		return raw, nil
 
 	case PaxARGB4:
 		raw := make([]byte, w*h*2)
-		i := 0
-		for y := b.Min.Y; y < b.Max.Y; y++ {
-			for x := b.Min.X; x < b.Max.X; x++ {
-				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
-				r := uint16(c.R >> 4)
-				g := uint16(c.G >> 4)
-				bl := uint16(c.B >> 4)
-				a := uint16(c.A >> 4)
+		nrgbaRows(img, func(y int, row []uint8) {
+			out := raw[y*w*2 : (y+1)*w*2]
+			for i, o := 0, 0; i < len(row); i, o = i+4, o+2 {
+				r := uint16(row[i+0] >> 4)
+				g := uint16(row[i+1] >> 4)
+				bl := uint16(row[i+2] >> 4)
+				a := uint16(row[i+3] >> 4)
 				p := (a << 12) | (bl << 8) | (g << 4) | r
-				binary.LittleEndian.PutUint16(raw[i:], p)
-				i += 2
+				binary.LittleEndian.PutUint16(out[o:], p)
 			}
-		}
+		})
 		return raw, nil
 
 	case PaxGRAYA:
 		raw := make([]byte, w*h*2)
-		i := 0
-		for y := b.Min.Y; y < b.Max.Y; y++ {
-			for x := b.Min.X; x < b.Max.X; x++ {
-				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
-				l := uint8(math.Round(0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)))
-				raw[i+0] = l
-				raw[i+1] = c.A
-				i += 2
+		nrgbaRows(img, func(y int, row []uint8) {
+			out := raw[y*w*2 : (y+1)*w*2]
+			for i, o := 0, 0; i < len(row); i, o = i+4, o+2 {
+				out[o+0] = grayLuma(color.NRGBA{R: row[i+0], G: row[i+1], B: row[i+2]})
+				out[o+1] = row[i+3]
 			}
-		}
+		})
 		return raw, nil
 
 	default:
 		return nil, ErrUnsupportedPixelFmt
 	}
 }
+
+// premultiplyAlpha returns an NRGBA copy of img with RGB multiplied by alpha.
+// Used for DXT2/DXT4, whose blocks carry premultiplied colors.
+func premultiplyAlpha(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	nrgbaRows(img, func(y int, row []uint8) {
+		dst := out.Pix[y*out.Stride : y*out.Stride+len(row)]
+		for i := 0; i < len(row); i += 4 {
+			a := uint16(row[i+3])
+			dst[i+0] = uint8((uint16(row[i+0])*a + 127) / 255) //nolint:gosec // G115: <= 255
+			dst[i+1] = uint8((uint16(row[i+1])*a + 127) / 255) //nolint:gosec // G115: <= 255
+			dst[i+2] = uint8((uint16(row[i+2])*a + 127) / 255) //nolint:gosec // G115: <= 255
+			dst[i+3] = row[i+3]
+		}
+	})
+
+	return out
+}
@@ -1,8 +1,8 @@
 package paa
 
 import (
+	"context"
 	"image"
-	"image/color"
 	"io"
 
 	"github.com/woozymasta/bcn"
@@ -11,7 +11,9 @@ import (
 
 // EncodeWithTexConfig resolves filename-based settings from a TexConvert config
 // and encodes the image using those settings. If no hint matches, it falls back
-// to EncodeWithOptions with auto format selection.
+// to EncodeWithOptions with auto format selection. Images whose dimensions are
+// not powers of two are rejected with ErrNonPowerOfTwo; use
+// EncodeWithTexConfigOptions with EncodeOptions.PowerOfTwo to scale, pad or allow them.
 func EncodeWithTexConfig(w io.Writer, img image.Image, name string, cfg texconfig.TexConvertConfig) error {
 	return EncodeWithTexConfigOptions(w, img, name, cfg, nil)
 }
@@ -19,9 +21,15 @@ func EncodeWithTexConfig(w io.Writer, img image.Image, name string, cfg texconfi
 // EncodeWithTexConfigOptions resolves filename-based settings from a TexConvert config,
 // applies optional overrides, and encodes the image using those settings.
 func EncodeWithTexConfigOptions(w io.Writer, img image.Image, name string, cfg texconfig.TexConvertConfig, override *EncodeOptions) error {
+	return EncodeWithTexConfigContext(context.Background(), w, img, name, cfg, override)
+}
+
+// EncodeWithTexConfigContext is EncodeWithTexConfigOptions with cancellation
+// and progress reports (override.Progress); see EncodeContext.
+func EncodeWithTexConfigContext(ctx context.Context, w io.Writer, img image.Image, name string, cfg texconfig.TexConvertConfig, override *EncodeOptions) error {
 	hint, ok := texconfig.Resolve(name, cfg)
 	if !ok {
-		opts := &EncodeOptions{}
+		opts := &EncodeOptions{PowerOfTwo: PowerOfTwoReject}
 		if !cfg.DisableLZO {
 			opts.UseLZO = true
 		}
@@ -33,16 +41,23 @@ func EncodeWithTexConfigOptions(w io.Writer, img image.Image, name string, cfg t
 			applyEncodeOverrides(opts, override)
 		}
 
-		return EncodeWithOptions(w, img, opts)
+		return EncodeContext(ctx, w, img, opts)
 	}
 
-	skipSwizzle := shouldSkipSwizzle(img, hint)
+	// One analysis serves every decision below and the CGVA/CXAM tags; it is
+	// only repeated when the pixels change.
+	an := analyzeImage(img)
+	skipSwizzle := shouldSkipSwizzle(an, hint)
 	if !skipSwizzle {
-		img = promoteAlphaFromRGBIfNeeded(img, hint)
+		if promoted, ok := promoteAlphaFromRGBIfNeeded(img, an, hint); ok {
+			img, an = promoted, analyzeImage(promoted)
+		}
 	}
 
-	img = autoReduceIfNeeded(img, hint, cfg)
-	opts, err := EncodeOptionsFromHint(img, hint, cfg, skipSwizzle)
+	if reduced, ok := autoReduceIfNeeded(img, hint, cfg); ok {
+		img, an = reduced, analyzeImage(reduced)
+	}
+	opts, err := encodeOptionsFromAnalysis(an, hint, cfg, skipSwizzle)
 	if err != nil {
 		return err
 	}
@@ -51,32 +66,32 @@ func EncodeWithTexConfigOptions(w io.Writer, img image.Image, name string, cfg t
 		applyEncodeOverrides(opts, override)
 	}
 
-	return EncodeWithOptions(w, img, opts)
+	return encodeContext(ctx, w, img, opts, &an.colors)
 }
 
 // EncodeOptionsFromHint converts a resolved TexConvert hint into EncodeOptions.
+// The result rejects non power-of-two images (PowerOfTwoReject), as the engine does.
 func EncodeOptionsFromHint(img image.Image, hint texconfig.TextureHint, cfg texconfig.TexConvertConfig, skipSwizzle bool) (*EncodeOptions, error) {
-	stats := scanAlpha(img)
+	return encodeOptionsFromAnalysis(analyzeImage(img), hint, cfg, skipSwizzle)
+}
+
+// encodeOptionsFromAnalysis is EncodeOptionsFromHint for an analyzed image.
+func encodeOptionsFromAnalysis(an imageAnalysis, hint texconfig.TextureHint, cfg texconfig.TexConvertConfig, skipSwizzle bool) (*EncodeOptions, error) {
+	stats := an.alpha
 
 	if isTexViewUnsupported(hint) {
 		return nil, ErrUnsupportedFormat
 	}
 
-	if hint.EnableDXT != nil && !*hint.EnableDXT {
-		switch hint.Format {
-		case texconfig.TexFormatARGB4444, texconfig.TexFormatARGB1555, texconfig.TexFormatAI88, texconfig.TexFormatP8:
-			// Allow explicit non-DXT formats when DXT is disabled.
-		default:
-			return nil, ErrUnsupportedFormat
-		}
-	}
-
 	paxType, err := selectPaxType(stats, hint)
 	if err != nil {
 		return nil, err
 	}
+	if hint.EnableDXT != nil && !*hint.EnableDXT && isDXT(paxType) {
+		paxType = selectUncompressedPaxType(an)
+	}
 
-	opts := &EncodeOptions{Type: paxType}
+	opts := &EncodeOptions{Type: paxType, PowerOfTwo: PowerOfTwoReject}
 	if isDXT(paxType) && !cfg.DisableLZO {
 		opts.UseLZO = true
 	}
@@ -114,16 +129,16 @@ func EncodeOptionsFromHint(img image.Image, hint texconfig.TextureHint, cfg texc
 	if !stats.allHigh {
 		opts.WriteGALF = true
 		if stats.isBinary {
-			opts.GALFValue = 2
+			opts.GALFValue = byte(AlphaBinary)
 		} else {
-			opts.GALFValue = 1
+			opts.GALFValue = byte(AlphaInterpolated)
 		}
 	}
 
 	// Apply GALF.
 	if isDetailHint(hint) {
 		opts.WriteGALF = true
-		opts.GALFValue = 2
+		opts.GALFValue = byte(AlphaBinary)
 	}
 
 	// Apply error metrics.
@@ -150,6 +165,17 @@ func EncodeOptionsFromHint(img image.Image, hint texconfig.TextureHint, cfg texc
 	if cfg.UseSRGBFromDynRange && hint.DynRange != nil && *hint.DynRange {
 		opts.UseSRGB = true
 	}
+	if cfg.ExpandDynRange && hint.DynRange != nil && *hint.DynRange {
+		opts.ExpandDynRange = true
+	}
+	if hint.Dithering != nil && *hint.Dithering {
+		if _, ok := ditherSteps(paxType); ok {
+			opts.Dither = DitherFloydSteinberg
+		}
+	}
+	if hint.AlphaCoverageRef > 0 && hint.AlphaCoverageRef <= 255 {
+		opts.AlphaCoverageRef = uint8(hint.AlphaCoverageRef) //nolint:gosec // G115
+	}
 
 	return opts, nil
 }
@@ -161,35 +187,6 @@ type alphaStats struct {
 	isBinary bool
 }
 
-// scanAlpha inspects the image alpha channel for GALF/format decisions.
-func scanAlpha(img image.Image) alphaStats {
-	b := img.Bounds()
-	stats := alphaStats{allHigh: true, isBinary: true}
-
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			_, _, _, a := img.At(x, y).RGBA()
-			a8 := uint8(a >> 8) //nolint:gosec // G115
-			if a8 < 255 {
-				stats.hasAlpha = true
-			}
-			if a8 < 0xF0 {
-				stats.allHigh = false
-			}
-			if a8 != 0 && a8 != 255 {
-				stats.isBinary = false
-			}
-		}
-	}
-
-	// If the image has no alpha, set the alpha channel to binary.
-	if !stats.hasAlpha {
-		stats.isBinary = true
-	}
-
-	return stats
-}
-
 // selectPaxType selects the PaxType based on the alpha channel and the hint.
 func selectPaxType(stats alphaStats, hint texconfig.TextureHint) (PaxType, error) {
 	switch hint.Format {
@@ -204,13 +201,15 @@ func selectPaxType(stats alphaStats, hint texconfig.TextureHint) (PaxType, error
 	// Supported DXT formats.
 	case texconfig.TexFormatDXT1:
 		return PaxDXT1, nil
+	case texconfig.TexFormatDXT2:
+		return PaxDXT2, nil
+	case texconfig.TexFormatDXT3:
+		return PaxDXT3, nil
+	case texconfig.TexFormatDXT4:
+		return PaxDXT4, nil
 	case texconfig.TexFormatDXT5:
 		return PaxDXT5, nil
 
-	// Unsupported DXT formats.
-	case texconfig.TexFormatDXT2, texconfig.TexFormatDXT3, texconfig.TexFormatDXT4:
-		return 0, ErrUnsupportedFormat
-
 	// Supported non-DXT formats.
 	case texconfig.TexFormatARGB4444:
 		return PaxARGB4, nil
@@ -218,28 +217,41 @@ func selectPaxType(stats alphaStats, hint texconfig.TextureHint) (PaxType, error
 		return PaxARGBA5, nil
 	case texconfig.TexFormatAI88:
 		return PaxGRAYA, nil
-
-	// Unsupported non-DXT formats.
 	case texconfig.TexFormatP8:
-		return 0, ErrUnsupportedFormat
+		return PaxP8, nil
 	default:
 		return 0, ErrUnsupportedFormat
 	}
 }
 
-// autoReduceIfNeeded reduces the image if the hint requires it.
-func autoReduceIfNeeded(img image.Image, hint texconfig.TextureHint, cfg texconfig.TexConvertConfig) image.Image {
+// selectUncompressedPaxType picks the TexConvert uncompressed format that
+// loses the least for an image when a hint disables DXT: AI88 for grayscale,
+// ARGB1555 for opaque or alpha-tested images, ARGB4444 for smooth alpha.
+func selectUncompressedPaxType(an imageAnalysis) PaxType {
+	switch {
+	case an.gray:
+		return PaxGRAYA
+	case !an.alpha.hasAlpha || an.alpha.isBinary:
+		return PaxARGBA5
+	default:
+		return PaxARGB4
+	}
+}
+
+// autoReduceIfNeeded reduces the image if the hint requires it and reports
+// whether it did.
+func autoReduceIfNeeded(img image.Image, hint texconfig.TextureHint, cfg texconfig.TexConvertConfig) (image.Image, bool) {
 	if cfg.DisableAutoReduce || hint.AutoReduce == nil || !*hint.AutoReduce {
-		return img
+		return img, false
 	}
 
 	b := img.Bounds()
 	if b.Dx() <= 0 || b.Dy() <= 0 {
-		return img
+		return img, false
 	}
 
 	if hint.LimitSize <= 0 {
-		return img
+		return img, false
 	}
 
 	maxDim := b.Dx()
@@ -247,7 +259,7 @@ func autoReduceIfNeeded(img image.Image, hint texconfig.TextureHint, cfg texconf
 		maxDim = b.Dy()
 	}
 	if maxDim <= hint.LimitSize {
-		return img
+		return img, false
 	}
 
 	useSRGB := cfg.UseSRGBFromDynRange && hint.DynRange != nil && *hint.DynRange
@@ -263,10 +275,10 @@ func autoReduceIfNeeded(img image.Image, hint texconfig.TextureHint, cfg texconf
 	}
 
 	if best != nil {
-		return best
+		return best, true
 	}
 
-	return img
+	return img, false
 }
 
 // applyEncodeOverrides applies the overrides to the EncodeOptions.
@@ -282,12 +294,39 @@ func applyEncodeOverrides(dst *EncodeOptions, override *EncodeOptions) {
 		dst.SkipSwizzle = true
 		dst.Swizzle = nil
 	}
+	if override.Progress != nil {
+		dst.Progress = override.Progress
+	}
+	if len(override.ExtraTags) > 0 {
+		dst.ExtraTags = append(dst.ExtraTags, override.ExtraTags...)
+	}
 
 	// Explicitly propagate ForceCXAMFull override.
 	dst.ForceCXAMFull = override.ForceCXAMFull
 	if override.ForceLZSS {
 		dst.ForceLZSS = true
 	}
+	if override.ExpandDynRange {
+		dst.ExpandDynRange = true
+	}
+	if override.PowerOfTwo != PowerOfTwoDefault {
+		dst.PowerOfTwo = override.PowerOfTwo
+	}
+	if override.ResampleFilter != ResampleDefault {
+		dst.ResampleFilter = override.ResampleFilter
+	}
+	if override.Dither != DitherDefault {
+		dst.Dither = override.Dither
+	}
+	if override.MipKernel != ResampleDefault {
+		dst.MipKernel = override.MipKernel
+	}
+	if override.WrapEdges {
+		dst.WrapEdges = true
+	}
+	if override.AlphaCoverageRef != 0 {
+		dst.AlphaCoverageRef = override.AlphaCoverageRef
+	}
 }
 
 // ensureBCnOptions ensures that the BCn options are set.
@@ -345,14 +384,13 @@ func mergeBCnOptions(dst *bcn.EncodeOptions, override *bcn.EncodeOptions) *bcn.E
 }
 
 // shouldSkipSwizzle checks if the swizzle should be skipped.
-func shouldSkipSwizzle(img image.Image, hint texconfig.TextureHint) bool {
+func shouldSkipSwizzle(an imageAnalysis, hint texconfig.TextureHint) bool {
 	if hint.Swizzle.IsIdentity() {
 		return false
 	}
 
 	if usesAlphaForRGB(hint.Swizzle) {
-		minA, maxA, minRGB, maxRGB := alphaAndRGBRange(img)
-		return minA == 255 && maxA == 255 && minRGB != maxRGB
+		return an.minA == 255 && an.maxA == 255 && an.minRGB != an.maxRGB
 	}
 	return false
 }
@@ -379,35 +417,35 @@ func isTexViewUnsupported(hint texconfig.TextureHint) bool {
 }
 
 // promoteAlphaFromRGBIfNeeded promotes the alpha channel from the RGB channel if needed.
-func promoteAlphaFromRGBIfNeeded(img image.Image, hint texconfig.TextureHint) image.Image {
+// It reports whether img was replaced; an is the analysis of img.
+func promoteAlphaFromRGBIfNeeded(img image.Image, an imageAnalysis, hint texconfig.TextureHint) (image.Image, bool) {
 	if !usesAlphaForRGB(hint.Swizzle) {
-		return img
+		return img, false
 	}
 
 	b := img.Bounds()
 	if b.Dx() <= 0 || b.Dy() <= 0 {
-		return img
+		return img, false
 	}
 
-	minA, maxA, minRGB, maxRGB := alphaAndRGBRange(img)
-	if minA != 255 || maxA != 255 {
-		return img
+	if an.minA != 255 || an.maxA != 255 {
+		return img, false
 	}
 
-	if minRGB == maxRGB {
-		return img
+	if an.minRGB == an.maxRGB {
+		return img, false
 	}
 
 	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
-			l := uint8((uint16(c.R) + uint16(c.G) + uint16(c.B)) / 3) //nolint:gosec // G115: clamped by uint16 sum
-			out.SetNRGBA(x, y, color.NRGBA{R: c.R, G: c.G, B: c.B, A: l})
+	nrgbaRows(img, func(y int, row []uint8) {
+		dst := out.Pix[y*out.Stride : y*out.Stride+len(row)]
+		copy(dst, row)
+		for i := 0; i < len(dst); i += 4 {
+			dst[i+3] = uint8((uint16(row[i]) + uint16(row[i+1]) + uint16(row[i+2])) / 3) //nolint:gosec // G115: clamped by uint16 sum
 		}
-	}
+	})
 
-	return out
+	return out, true
 }
 
 // usesAlphaForRGB checks if the swizzle uses the alpha channel for the RGB channels.
@@ -418,42 +456,3 @@ func usesAlphaForRGB(swz texconfig.ChannelSwizzle) bool {
 		swz.B.Source == texconfig.SwizzleA && !swz.B.Invert && !swz.B.IsConst &&
 		swz.A.Valid && swz.A.IsConst && swz.A.ConstValue == 255
 }
-
-// alphaAndRGBRange checks the alpha and RGB channel ranges.
-func alphaAndRGBRange(img image.Image) (minA, maxA uint8, minRGB, maxRGB uint8) {
-	minA, maxA = 255, 0
-	minRGB, maxRGB = 255, 0
-	b := img.Bounds()
-
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
-			if c.A < minA {
-				minA = c.A
-			}
-			if c.A > maxA {
-				maxA = c.A
-			}
-			if c.R < minRGB {
-				minRGB = c.R
-			}
-			if c.G < minRGB {
-				minRGB = c.G
-			}
-			if c.B < minRGB {
-				minRGB = c.B
-			}
-			if c.R > maxRGB {
-				maxRGB = c.R
-			}
-			if c.G > maxRGB {
-				maxRGB = c.G
-			}
-			if c.B > maxRGB {
-				maxRGB = c.B
-			}
-		}
-	}
-
-	return minA, maxA, minRGB, maxRGB
-}
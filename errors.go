@@ -10,6 +10,11 @@ var (
 	ErrInvalidMagic = errors.New("paa: invalid magic/format")
 	// ErrMissingSFFO is returned when the SFFO tag is missing.
 	ErrMissingSFFO = errors.New("paa: missing SFFO tag")
+	// ErrSFFOMismatch reports mip blocks that are not where the SFFO table says
+	// (StreamDecoder.SFFOError).
+	ErrSFFOMismatch = errors.New("paa: SFFO offsets do not match mip layout")
+	// ErrMipLevelOutOfRange is returned when a requested mip level does not exist.
+	ErrMipLevelOutOfRange = errors.New("paa: mip level out of range")
 	// ErrInsufficientData is returned when there is not enough data for ARGB8.
 	ErrInsufficientData = errors.New("paa: not enough data for ARGB8")
 	// ErrUnsupportedPixelFmt is returned when the pixel format is unsupported for decode.
@@ -22,6 +27,12 @@ var (
 	ErrLZSSDecompress = errors.New("paa: LZSS decompression failed")
 	// ErrDXTDecode is returned when DXT decode failed.
 	ErrDXTDecode = errors.New("paa: DXT decode failed")
+	// ErrLimitExceeded is returned when input exceeds a DecodeOptions limit.
+	ErrLimitExceeded = errors.New("paa: decode limit exceeded")
+	// ErrInvalidMipChain is returned when supplied mip levels do not halve in size.
+	ErrInvalidMipChain = errors.New("paa: mip levels do not form a halving chain")
+	// ErrNonPowerOfTwo is returned when EncodeOptions.PowerOfTwo rejects the image size.
+	ErrNonPowerOfTwo = errors.New("paa: dimensions are not a power of two")
 	// ErrInvalidDimensions is returned when the dimensions exceed the PAA uint16 range (0-65535).
 	ErrInvalidDimensions = errors.New("paa: dimensions exceed PAA uint16 range (0-65535)")
 )
@@ -0,0 +1,63 @@
+package paa
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fuzzDecodeOptions bounds memory so that crafted inputs fail with
+// ErrLimitExceeded instead of exhausting the fuzzer.
+var fuzzDecodeOptions = &DecodeOptions{
+	MaxTotalBytes: 64 << 20,
+	MaxWidth:      4096,
+	MaxHeight:     4096,
+	MaxTagSize:    4096,
+	MaxMipCount:   16,
+}
+
+// addFuzzSeeds adds the testdata fixtures and a few small encoded files.
+func addFuzzSeeds(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join("testdata", "test_*.paa"))
+	for _, path := range files {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatalf("read fixture: %v", err)
+		}
+		if len(raw) <= 64<<10 {
+			f.Add(raw)
+		}
+	}
+
+	for _, typ := range []PaxType{PaxDXT1, PaxDXT5, PaxARGB8, PaxARGBA5, PaxARGB4, PaxGRAYA, PaxP8} {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, genColorAlpha(), &EncodeOptions{Type: typ}); err != nil {
+			f.Fatalf("EncodeWithOptions(%v): %v", typ, err)
+		}
+		f.Add(buf.Bytes())
+	}
+}
+
+func FuzzDecodePAA(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(_ *testing.T, data []byte) {
+		p, err := DecodePAAWithOptions(bytes.NewReader(data), fuzzDecodeOptions)
+		if err != nil {
+			return
+		}
+
+		for _, mm := range p.MipMaps {
+			_, _ = mm.Image()
+		}
+		_ = Validate(p)
+	})
+}
+
+func FuzzDecodeMetadata(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(_ *testing.T, data []byte) {
+		_, _ = DecodeMetadataWithOptions(bytes.NewReader(data), fuzzDecodeOptions)
+		_, _ = DecodeConfig(bytes.NewReader(data))
+	})
+}
@@ -0,0 +1,196 @@
+package paa
+
+import (
+	"image"
+	"image/color"
+)
+
+// nrgbaRows calls fn for each row of img, top to bottom, with its pixels as
+// non-premultiplied RGBA bytes, exactly as color.NRGBAModel converts them.
+//
+// Rows of *image.NRGBA are passed without copying; *image.RGBA, *image.Gray,
+// *image.Paletted and *image.YCbCr are converted from their planes directly
+// and other types through At. fn must not modify or keep row.
+func nrgbaRows(img image.Image, fn func(y int, row []uint8)) {
+	b := img.Bounds()
+	w := b.Dx()
+	if w <= 0 || b.Dy() <= 0 {
+		return
+	}
+
+	if src, ok := img.(*image.NRGBA); ok {
+		for y := 0; y < b.Dy(); y++ {
+			o := src.PixOffset(b.Min.X, b.Min.Y+y)
+			fn(y, src.Pix[o:o+w*4])
+		}
+
+		return
+	}
+
+	row := make([]uint8, w*4)
+	switch src := img.(type) {
+	case *image.RGBA:
+		for y := 0; y < b.Dy(); y++ {
+			o := src.PixOffset(b.Min.X, b.Min.Y+y)
+			unpremultiplyRow(row, src.Pix[o:o+w*4])
+			fn(y, row)
+		}
+
+	case *image.Gray:
+		for y := 0; y < b.Dy(); y++ {
+			o := src.PixOffset(b.Min.X, b.Min.Y+y)
+			for x, v := range src.Pix[o : o+w] {
+				row[x*4+0], row[x*4+1], row[x*4+2], row[x*4+3] = v, v, v, 255
+			}
+			fn(y, row)
+		}
+
+	case *image.Paletted:
+		// Indexes past the palette come out transparent black; a uint8 index
+		// never reaches entries past 256, so they are ignored.
+		var lut [256][4]uint8
+		for i, c := range src.Palette[:min(len(src.Palette), len(lut))] {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			lut[i] = [4]uint8{n.R, n.G, n.B, n.A}
+		}
+		for y := 0; y < b.Dy(); y++ {
+			o := src.PixOffset(b.Min.X, b.Min.Y+y)
+			for x, i := range src.Pix[o : o+w] {
+				copy(row[x*4:x*4+4], lut[i][:])
+			}
+			fn(y, row)
+		}
+
+	case *image.YCbCr:
+		// color.YCbCrToRGB gives the high bytes of color.YCbCr.RGBA.
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < w; x++ {
+				yi := src.YOffset(b.Min.X+x, b.Min.Y+y)
+				ci := src.COffset(b.Min.X+x, b.Min.Y+y)
+				r, g, bl := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+				row[x*4+0], row[x*4+1], row[x*4+2], row[x*4+3] = r, g, bl, 255
+			}
+			fn(y, row)
+		}
+
+	default:
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < w; x++ {
+				c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+				row[x*4+0], row[x*4+1], row[x*4+2], row[x*4+3] = c.R, c.G, c.B, c.A
+			}
+			fn(y, row)
+		}
+	}
+}
+
+// unpremultiplyRow converts premultiplied RGBA bytes to NRGBA with the same
+// 16-bit arithmetic as color.NRGBAModel.
+func unpremultiplyRow(dst, src []uint8) {
+	for i := 0; i+3 < len(src); i += 4 {
+		a := src[i+3]
+		switch a {
+		case 255:
+			copy(dst[i:i+4], src[i:i+4])
+		case 0:
+			dst[i+0], dst[i+1], dst[i+2], dst[i+3] = 0, 0, 0, 0
+		default:
+			a16 := uint32(a) * 0x101
+			for c := 0; c < 3; c++ {
+				dst[i+c] = uint8((uint32(src[i+c]) * 0x101 * 0xffff / a16) >> 8) //nolint:gosec // G115: premultiplied value <= alpha
+			}
+			dst[i+3] = a
+		}
+	}
+}
+
+// toNRGBA converts an image.Image to *image.NRGBA without premultiplication.
+// An *image.NRGBA is returned as is; other images are copied to a new image
+// with its origin at (0, 0).
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok {
+		return n
+	}
+
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	nrgbaRows(img, func(y int, row []uint8) {
+		copy(out.Pix[y*out.Stride:], row)
+	})
+
+	return out
+}
+
+// imageAnalysis is what the encoder needs to know about the pixels of an
+// image: CGVA/CXAM statistics, alpha classification for format and GALF
+// selection, and the channel ranges used by the alpha-from-RGB promotion.
+type imageAnalysis struct {
+	colors colorStats
+	alpha  alphaStats
+	// minA, maxA are the alpha range; minRGB, maxRGB the range over R, G and B.
+	minA, maxA     uint8
+	minRGB, maxRGB uint8
+	// gray is set when every pixel has R == G == B.
+	gray bool
+}
+
+// analyzeImage gathers imageAnalysis in a single pass over img.
+func analyzeImage(img image.Image) imageAnalysis {
+	var sum [4]uint64
+	var alphaHist [256]int
+	an := imageAnalysis{minRGB: 255, gray: true}
+	nrgbaRows(img, func(_ int, row []uint8) {
+		var rowSum [4]uint64
+		for i := 0; i < len(row); i += 4 {
+			r, g, b, a := row[i], row[i+1], row[i+2], row[i+3]
+			rowSum[0] += uint64(r)
+			rowSum[1] += uint64(g)
+			rowSum[2] += uint64(b)
+			rowSum[3] += uint64(a)
+			alphaHist[a]++
+
+			an.colors.max[0] = max(an.colors.max[0], r)
+			an.colors.max[1] = max(an.colors.max[1], g)
+			an.colors.max[2] = max(an.colors.max[2], b)
+			an.colors.max[3] = max(an.colors.max[3], a)
+			an.minRGB = min(an.minRGB, r, g, b)
+			if r != g || g != b {
+				an.gray = false
+			}
+		}
+		for c := range sum {
+			sum[c] += rowSum[c]
+		}
+	})
+
+	bounds := img.Bounds()
+	pixelCount := uint64(bounds.Dx()) * uint64(bounds.Dy()) //nolint:gosec // bounds are non-negative
+	if pixelCount == 0 {
+		// Matches the empty-range results of the former per-purpose scans.
+		an.minA = 255
+		an.alpha = alphaStats{allHigh: true, isBinary: true}
+		return an
+	}
+
+	for c := range sum {
+		an.colors.avg[c] = uint8(sum[c] / pixelCount) //nolint:gosec // G115: average of bytes
+	}
+	an.maxRGB = max(an.colors.max[0], an.colors.max[1], an.colors.max[2])
+	an.maxA = an.colors.max[3]
+	for a, n := range alphaHist {
+		if n > 0 {
+			an.minA = uint8(a) //nolint:gosec // G115: histogram index
+			break
+		}
+	}
+
+	an.colors.hasAlpha = an.minA < 255
+	an.alpha = alphaStats{
+		hasAlpha: an.colors.hasAlpha,
+		allHigh:  an.minA >= 0xF0,
+		// Without any translucent pixel the alpha is binary (or absent).
+		isBinary: alphaHist[0]+alphaHist[255] == int(pixelCount), //nolint:gosec // G115: pixel count of an image
+	}
+
+	return an
+}
@@ -20,4 +20,5 @@ func init() {
 	image.RegisterFormat("paa_argb1555", "\x55\x15", paa.Decode, paa.DecodeConfig)
 	image.RegisterFormat("paa_argb8", "\x88\x88", paa.Decode, paa.DecodeConfig)
 	image.RegisterFormat("paa_graya", "\x80\x80", paa.Decode, paa.DecodeConfig)
+	image.RegisterFormat("paa_p8", "GGAT", paa.Decode, paa.DecodeConfig)
 }
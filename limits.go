@@ -0,0 +1,145 @@
+package paa
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxTagSize is the GGAT payload limit used when DecodeOptions.MaxTagSize
+// is zero. Tags known to the engine are at most 64 bytes (SFFO).
+const DefaultMaxTagSize = 1 << 20
+
+// Upper bounds of the decompressed/stored size ratio. LZSS emits at most 18 bytes
+// per 2-byte back-reference (plus one flag byte per 8 tokens); LZO1X extends a
+// match by 255 bytes per extra length byte. Larger claims cannot be valid and are
+// rejected before the decompressor allocates its output.
+const (
+	maxLZSSRatio = 9
+	maxLZORatio  = 256
+)
+
+// payloadChunk is the size above which payloads are read progressively.
+const payloadChunk = 64 << 10
+
+// decodeLimits enforces DecodeOptions resource limits for a single decode.
+type decodeLimits struct {
+	// used is the number of bytes accounted so far.
+	used int64
+	// maxTotal is DecodeOptions.MaxTotalBytes (0 = unlimited).
+	maxTotal int64
+	// maxTag is the GGAT payload limit.
+	maxTag int
+	// maxWidth and maxHeight limit mip dimensions (0 = unlimited).
+	maxWidth  int
+	maxHeight int
+	// maxMips is DecodeOptions.MaxMipCount (0 = unlimited).
+	maxMips int
+	// mips is the number of mip headers seen so far.
+	mips int
+}
+
+// newDecodeLimits returns limits from opts; nil opts only limits tag size.
+func newDecodeLimits(opts *DecodeOptions) *decodeLimits {
+	l := &decodeLimits{maxTag: DefaultMaxTagSize}
+	if opts == nil {
+		return l
+	}
+
+	if opts.MaxTagSize > 0 {
+		l.maxTag = opts.MaxTagSize
+	}
+	l.maxTotal = opts.MaxTotalBytes
+	l.maxWidth = opts.MaxWidth
+	l.maxHeight = opts.MaxHeight
+	l.maxMips = opts.MaxMipCount
+
+	return l
+}
+
+// tag checks one GGAT payload size and accounts for it.
+func (l *decodeLimits) tag(name string, size uint32) error {
+	if uint64(size) > uint64(l.maxTag) { //nolint:gosec // maxTag is positive
+		return fmt.Errorf("%w: tag %q is %d bytes, limit %d", ErrLimitExceeded, name, size, l.maxTag)
+	}
+
+	return l.alloc(int64(size))
+}
+
+// mip checks the dimensions of the next mip header and the mip count.
+func (l *decodeLimits) mip(w, h uint16) error {
+	l.mips++
+	if l.maxMips > 0 && l.mips > l.maxMips {
+		return fmt.Errorf("%w: more than %d mips", ErrLimitExceeded, l.maxMips)
+	}
+	if l.maxWidth > 0 && int(w) > l.maxWidth {
+		return fmt.Errorf("%w: mip width %d, limit %d", ErrLimitExceeded, w, l.maxWidth)
+	}
+	if l.maxHeight > 0 && int(h) > l.maxHeight {
+		return fmt.Errorf("%w: mip height %d, limit %d", ErrLimitExceeded, h, l.maxHeight)
+	}
+
+	return nil
+}
+
+// image accounts for the image decoded from mm: NRGBA, or one index per pixel
+// for P8.
+func (l *decodeLimits) image(mm *MipMap) error {
+	n := int64(mm.Width) * int64(mm.Height)
+	if mm.Type != PaxP8 {
+		n *= 4
+	}
+
+	return l.alloc(n)
+}
+
+// alloc accounts for n more bytes held in memory.
+func (l *decodeLimits) alloc(n int64) error {
+	l.used += n
+	if l.maxTotal > 0 && l.used > l.maxTotal {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrLimitExceeded, l.used, l.maxTotal)
+	}
+
+	return nil
+}
+
+// readAll reads r to the end, failing once more than MaxTotalBytes would be buffered.
+func (l *decodeLimits) readAll(r io.Reader) ([]byte, error) {
+	if l.maxTotal <= 0 {
+		return io.ReadAll(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, l.maxTotal-l.used+1))
+	if err != nil {
+		return nil, err
+	}
+	if err := l.alloc(int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readPayload reads exactly n bytes. Large payloads are read progressively, so
+// a bogus size in a truncated file fails with io.ErrUnexpectedEOF instead of
+// allocating n bytes up front.
+func readPayload(r io.Reader, n int) ([]byte, error) {
+	if n <= payloadChunk {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		return buf, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
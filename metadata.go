@@ -2,17 +2,26 @@ package paa
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
+	"math"
 )
 
 // Metadata contains lightweight PAA information without mip payload decode.
 type Metadata struct {
 	// Taggs stores raw GGAT entries by 4-byte key.
 	Taggs map[string][]byte
+	// Tags stores GGAT entries in file order, including unknown tags and offsets.
+	Tags TagList
 	// MipHeaders stores mip offset and dimensions from SFFO.
 	MipHeaders []MipHeader
+	// Palette stores raw palette triplets (BGR) for indexed P8 textures.
+	Palette []byte
 	// Type is texture pax type from file header.
 	Type PaxType
+	// DerivedOffsets is true when SFFO was missing or unusable and mip offsets
+	// were derived by walking the mip chain.
+	DerivedOffsets bool
 }
 
 // MipHeader stores minimum per-mip information for metadata consumers.
@@ -27,67 +36,162 @@ type MipHeader struct {
 
 // DecodeMetadata reads PAA metadata without decoding mip payload bytes.
 //
-// It parses PaxType, GGAT tags, and SFFO-referenced mip headers (width/height).
+// It parses PaxType, GGAT tags, palette, and SFFO-referenced mip headers (width/height).
+// Without a usable SFFO, mip headers are found by walking the mip chain.
 // For DXT formats, width top bit is masked out when LZO flag is present.
 func DecodeMetadata(r io.Reader) (*Metadata, error) {
-	var err error
-	r, seeker, err := ensureSeeker(r)
+	return DecodeMetadataWithOptions(r, nil)
+}
+
+// DecodeMetadataWithOptions is DecodeMetadata with resource limits from opts
+// (tag size, dimensions, mip count, buffered bytes); see DecodeOptions.
+func DecodeMetadataWithOptions(r io.Reader, opts *DecodeOptions) (*Metadata, error) {
+	lim := newDecodeLimits(opts)
+	r, seeker, err := ensureSeeker(r, lim)
 	if err != nil {
 		return nil, err
 	}
 
-	pType, err := readPaxType(r)
+	return decodeMetadata(r, seeker, lim)
+}
+
+// decodeMetadata reads metadata from a seekable reader positioned at file start.
+func decodeMetadata(r io.Reader, seeker io.Seeker, lim *decodeLimits) (*Metadata, error) {
+	h, _, err := readHeader(r, lim)
 	if err != nil {
 		return nil, err
 	}
 
-	tags, err := readGGATTags(r)
-	if err != nil {
-		return nil, err
+	taggs := h.tags.Map()
+	m := &Metadata{
+		Type:    h.typ,
+		Taggs:   taggs,
+		Tags:    h.tags,
+		Palette: h.palette,
 	}
 
-	offsets, err := sffoOffsets(tags)
+	mark := *lim
+	offsets, err := sffoOffsets(taggs)
 	if err != nil {
+		err = h.sffoError(err)
+	} else {
+		m.MipHeaders, err = readMipHeadersAt(r, seeker, m.Type, offsets, lim)
+	}
+	if errors.Is(err, ErrLimitExceeded) {
 		return nil, err
 	}
 
-	m := &Metadata{
-		Type:       pType,
-		Taggs:      tags,
-		MipHeaders: make([]MipHeader, 0, 16),
+	// Missing or unusable SFFO: derive offsets by walking the mip chain.
+	if err != nil {
+		*lim = mark
+		headers, werr := walkMipHeaders(r, seeker, m.Type, h.mipStart, lim)
+		if errors.Is(werr, ErrLimitExceeded) {
+			return nil, werr
+		}
+		if werr != nil {
+			return nil, err
+		}
+
+		m.MipHeaders = headers
+		m.DerivedOffsets = true
+	}
+	if len(m.MipHeaders) == 0 {
+		// Keep the SFFO error of a fallback that found nothing either.
+		return nil, errors.Join(ErrNoMipmaps, err)
 	}
 
+	return m, nil
+}
+
+// readMipHeadersAt reads mip dimensions at the given absolute file offsets.
+func readMipHeadersAt(r io.Reader, seeker io.Seeker, paxType PaxType, offsets []uint32, lim *decodeLimits) ([]MipHeader, error) {
+	headers := make([]MipHeader, 0, 16)
 	for _, offset := range offsets {
 		if _, err := seeker.Seek(int64(offset), io.SeekStart); err != nil {
-			return nil, err
+			return nil, mipError(err, paxType, len(headers), int64(offset))
 		}
 
-		var w uint16
-		if err := binary.Read(r, binary.LittleEndian, &w); err != nil {
-			return nil, err
+		w, h, err := readMipDimensions(r, paxType)
+		if err != nil {
+			return nil, mipError(err, paxType, len(headers), int64(offset))
 		}
 
-		var h uint16
-		if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
-			return nil, err
+		if w == 0 && h == 0 {
+			continue
+		}
+		if err := lim.mip(w, h); err != nil {
+			return nil, mipError(err, paxType, len(headers), int64(offset))
+		}
+
+		headers = append(headers, MipHeader{
+			Width:  w,
+			Height: h,
+			Offset: offset,
+		})
+	}
+
+	return headers, nil
+}
+
+// walkMipHeaders reads mip headers sequentially from start, skipping payloads,
+// until the zero terminator (or a clean end of stream).
+func walkMipHeaders(r io.Reader, seeker io.Seeker, paxType PaxType, start int64, lim *decodeLimits) ([]MipHeader, error) {
+	headers := make([]MipHeader, 0, 16)
+	offset := start
+	for {
+		if offset > math.MaxUint32 {
+			return nil, mipError(ErrInsufficientData, paxType, len(headers), offset)
+		}
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, mipError(err, paxType, len(headers), offset)
+		}
+
+		w, h, err := readMipDimensions(r, paxType)
+		if err == io.EOF {
+			return headers, nil
+		}
+		if err != nil {
+			return nil, mipError(err, paxType, len(headers), offset)
 		}
 
 		if w == 0 && h == 0 {
-			continue
+			return headers, nil
+		}
+		if err := lim.mip(w, h); err != nil {
+			return nil, mipError(err, paxType, len(headers), offset)
 		}
 
-		if isDXTPaxType(m.Type) && (w&0x8000) != 0 {
-			w &= 0x7FFF
+		var sizeBuf [3]byte
+		if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+			return nil, mipError(err, paxType, len(headers), offset)
 		}
+		size := int64(sizeBuf[0]) | int64(sizeBuf[1])<<8 | int64(sizeBuf[2])<<16
 
-		m.MipHeaders = append(m.MipHeaders, MipHeader{
+		headers = append(headers, MipHeader{
 			Width:  w,
 			Height: h,
-			Offset: offset,
+			Offset: uint32(offset), //nolint:gosec // G115: checked above
 		})
+
+		offset += 7 + size
 	}
+}
 
-	return m, nil
+// readMipDimensions reads mip width and height, masking the DXT LZO flag.
+// It returns io.EOF only when no header bytes are left.
+func readMipDimensions(r io.Reader, paxType PaxType) (uint16, uint16, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, 0, err
+	}
+
+	w := binary.LittleEndian.Uint16(buf[0:2])
+	h := binary.LittleEndian.Uint16(buf[2:4])
+	if isDXTPaxType(paxType) && (w&0x8000) != 0 {
+		w &= 0x7FFF
+	}
+
+	return w, h, nil
 }
 
 // isDXTPaxType reports whether pax type is DXT-based.
@@ -3,6 +3,7 @@ package paa
 import (
 	"bytes"
 	"encoding/binary"
+	"image/color"
 	"os"
 	"path/filepath"
 	"strings"
@@ -96,3 +97,66 @@ func nonZeroSFFOOffsets(sffo []byte) []uint32 {
 
 	return out
 }
+
+func TestTagAccessors(t *testing.T) {
+	t.Parallel()
+
+	raw, err := os.ReadFile(filepath.Join("testdata", "test_nohq.paa"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	p, err := DecodePAA(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	m, err := DecodeMetadata(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("DecodeMetadata: %v", err)
+	}
+
+	cgva := p.Taggs["CGVA"]
+	avg, ok := m.AverageColor()
+	if !ok || avg != (color.NRGBA{R: cgva[2], G: cgva[1], B: cgva[0], A: cgva[3]}) {
+		t.Errorf("AverageColor=%v ok=%v, want BGRA of % x", avg, ok, cgva)
+	}
+	if mx, ok := m.MaxColor(); !ok || mx != (color.NRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("MaxColor=%v ok=%v, want full white", mx, ok)
+	}
+
+	swz, ok := m.Swizzle()
+	if !ok {
+		t.Fatalf("Swizzle not found")
+	}
+	want := "R=1-A G=G B=B A=1-R"
+	if got := swz.String(); got != want {
+		t.Errorf("Swizzle=%q, want %q", got, want)
+	}
+
+	offsets, err := m.MipOffsets()
+	if err != nil || len(offsets) != len(m.MipHeaders) || offsets[0] != m.MipHeaders[0].Offset {
+		t.Errorf("MipOffsets=%v err=%v, want offsets of %d mips", offsets, err, len(m.MipHeaders))
+	}
+
+	// Setters write the same bytes back.
+	c := &PAA{}
+	c.SetAverageColor(avg)
+	if !bytes.Equal(c.Taggs["CGVA"], cgva) {
+		t.Errorf("SetAverageColor wrote % x, want % x", c.Taggs["CGVA"], cgva)
+	}
+	if err := c.SetSwizzle(swz); err != nil {
+		t.Fatalf("SetSwizzle: %v", err)
+	}
+	if !bytes.Equal(c.Taggs["ZIWS"], p.Taggs["ZIWS"]) {
+		t.Errorf("SetSwizzle wrote % x, want % x", c.Taggs["ZIWS"], p.Taggs["ZIWS"])
+	}
+
+	c.SetAlphaFlags(AlphaBinary)
+	if f, ok := c.AlphaFlags(); !ok || f != AlphaBinary || len(c.Tags) != 3 {
+		t.Errorf("AlphaFlags=%v ok=%v tags=%d, want binary", f, ok, len(c.Tags))
+	}
+	c.SetAlphaFlags(0)
+	if _, ok := c.AlphaFlags(); ok {
+		t.Errorf("SetAlphaFlags(0) kept GALF")
+	}
+}
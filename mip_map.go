@@ -3,7 +3,9 @@ package paa
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	"io"
 
 	"github.com/woozymasta/bcn"
@@ -12,18 +14,69 @@ import (
 )
 
 // MipMap holds one mip level: dimensions and raw decoded pixel/block data.
+//
+// A mip read by DecodePAA, DecodePAAStream or a StreamDecoder belongs to the
+// file it was read from: Image and ImageWithOptions use that file's current
+// Palette and ZIWS/CXAM tags, so PAA.SetTag, DeleteTag and the typed setters
+// affect how its mips decode. Mips built by hand or moved to another PAA keep
+// no such link to it; PAA.Images decodes any mips with the tags of its receiver.
 type MipMap struct {
 	Data   []byte  // Raw decoded pixel/block data.
 	Type   PaxType // Type is the PaxType of the mipmap.
 	Width  uint16  // Width is the width of the mipmap.
 	Height uint16  // Height is the height of the mipmap.
+	// file is the PAA the mip was read into, nil for mips built by hand.
+	file *PAA
+	// stored is the compressed payload read from the file and storedData the
+	// Data it decompressed to. PAA.WriteTo writes stored back while Data is
+	// still that slice; SetData drops it.
+	stored     []byte
+	storedData []byte
+	// Compressed reports whether the mip was stored compressed (LZO for DXT, LZSS otherwise).
+	// PAA.WriteTo re-applies the same compression when it is set.
+	Compressed bool
+}
+
+// SetData replaces the mip payload. A mip read from a compressed block is
+// written back by PAA.WriteTo with its stored bytes until Data is replaced or
+// set through SetData, so call SetData(m.Data) after editing Data in place.
+func (m *MipMap) SetData(data []byte) {
+	m.Data = data
+	m.stored, m.storedData = nil, nil
+}
+
+// storedCurrent reports whether the stored payload still holds Data, i.e.
+// Data is the slice it decompressed to and SetData has not been called.
+func (m *MipMap) storedCurrent() bool {
+	return m.stored != nil && len(m.Data) > 0 && len(m.Data) == len(m.storedData) &&
+		&m.Data[0] == &m.storedData[0]
 }
 
 // readMipMap reads one mipmap from r at the current position.
 // Format: width (2), height (2), size (3 bytes LE), data (size bytes).
 // For Arma2+ DXT, the top bit of width indicates LZO compression; it is masked for dimensions.
 // Returns (nil, nil) for the dummy mip (width==0 && height==0).
-func readMipMap(r io.Reader, paxType PaxType) (*MipMap, error) {
+func readMipMap(r io.Reader, paxType PaxType, lim *decodeLimits) (*MipMap, error) {
+	b, err := readStoredMip(r, paxType, lim)
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	return b.decompress(paxType, lim)
+}
+
+// storedMip is one mip block as stored in the file, before decompression.
+type storedMip struct {
+	payload []byte
+	width   uint16
+	height  uint16
+	lzoFlag bool
+}
+
+// readStoredMip reads one mip block header and its stored payload without
+// decompressing it. Returns (nil, nil) for the terminator block.
+// Dimensions and the stored size are checked against lim before reading the payload.
+func readStoredMip(r io.Reader, paxType PaxType, lim *decodeLimits) (*storedMip, error) {
 	var w, h uint16
 	if err := binary.Read(r, binary.LittleEndian, &w); err != nil {
 		return nil, err
@@ -41,7 +94,9 @@ func readMipMap(r io.Reader, paxType PaxType) (*MipMap, error) {
 	if lzoFlag {
 		width = w & 0x7FFF
 	}
-	height := h
+	if err := lim.mip(width, h); err != nil {
+		return nil, err
+	}
 
 	var sizeBuf [3]byte
 	if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
@@ -49,46 +104,77 @@ func readMipMap(r io.Reader, paxType PaxType) (*MipMap, error) {
 	}
 	storedSize := int(sizeBuf[0]) | int(sizeBuf[1])<<8 | int(sizeBuf[2])<<16
 
-	payload := make([]byte, storedSize)
-	if _, err := io.ReadFull(r, payload); err != nil {
+	if err := lim.alloc(int64(storedSize)); err != nil {
 		return nil, err
 	}
 
-	expectedRaw := expectedMipSize(paxType, int(width), int(height))
+	payload, err := readPayload(r, storedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedMip{
+		payload: payload,
+		width:   width,
+		height:  h,
+		lzoFlag: lzoFlag,
+	}, nil
+}
+
+// decompress returns the mip with its payload decompressed (LZO for DXT, LZSS otherwise).
+// The decompressed size is checked against lim and the format's maximum
+// compression ratio before the output buffer is allocated.
+func (b *storedMip) decompress(paxType PaxType, lim *decodeLimits) (*MipMap, error) {
+	expectedRaw := expectedMipSize(paxType, int(b.width), int(b.height))
 	if expectedRaw < 0 {
 		return nil, ErrUnsupportedPixelFmt
 	}
 
-	var raw []byte
-	if storedSize == expectedRaw {
-		raw = payload
+	if len(b.payload) != expectedRaw {
+		stage, ratio := StageLZSS, maxLZSSRatio
+		if isDXT(paxType) {
+			stage, ratio = StageLZO, maxLZORatio
+		}
+		if expectedRaw > len(b.payload)*ratio+ratio {
+			err := fmt.Errorf("%w: %d stored bytes cannot hold %dx%d mip", ErrInsufficientData, len(b.payload), b.width, b.height)
+			return nil, decodeError(stage, paxType, -1, err)
+		}
+		if err := lim.alloc(int64(expectedRaw)); err != nil {
+			return nil, err
+		}
+	}
+
+	var raw, stored []byte
+	compressed := len(b.payload) != expectedRaw
+	if !compressed {
+		raw = b.payload
 	} else if isDXT(paxType) {
 		// DXT: only LZO when top bit of width is set.
-		if !lzoFlag {
-			return nil, ErrInsufficientData
+		if !b.lzoFlag {
+			return nil, decodeError(StageLZO, paxType, -1, ErrInsufficientData)
 		}
-		dec, err := lzo.Decompress(payload, lzo.DefaultDecompressOptions(expectedRaw))
+		dec, err := lzo.Decompress(b.payload, lzo.DefaultDecompressOptions(expectedRaw))
 		if err != nil {
-			if errors.Is(err, lzo.ErrLookBehindUnderrun) || errors.Is(err, lzo.ErrInputOverrun) {
-				return nil, errors.Join(ErrLZODecompress, err)
-			}
-			return nil, errors.Join(ErrLZODecompress, err)
+			return nil, decodeError(StageLZO, paxType, -1, errors.Join(ErrLZODecompress, err))
 		}
-		raw = dec
+		raw, stored = dec, b.payload
 	} else {
 		// Non-DXT: LZSS (signed checksum, lenient).
-		dec, err := lzss.Decompress(payload, expectedRaw, lzss.SignedLenientOptions())
+		dec, err := lzss.Decompress(b.payload, expectedRaw, lzss.SignedLenientOptions())
 		if err != nil {
-			return nil, errors.Join(ErrLZSSDecompress, err)
+			return nil, decodeError(StageLZSS, paxType, -1, errors.Join(ErrLZSSDecompress, err))
 		}
-		raw = dec
+		raw, stored = dec, b.payload
 	}
 
 	return &MipMap{
-		Width:  width,
-		Height: height,
-		Data:   raw,
-		Type:   paxType,
+		Width:      b.width,
+		Height:     b.height,
+		Data:       raw,
+		Type:       paxType,
+		stored:     stored,
+		storedData: raw,
+		Compressed: compressed,
 	}, nil
 }
 
@@ -109,27 +195,71 @@ func expectedMipSize(paxType PaxType, width, height int) int {
 		return width * height * 2
 	case PaxGRAYA:
 		return width * height * 2
+	case PaxP8:
+		return width * height
 	default:
 		return -1
 	}
 }
 
-// Image decodes the mipmap into an image.Image (NRGBA for non-DXT, DXT decoded via bcn).
+// Image decodes the mipmap into an image.Image (NRGBA for non-DXT, DXT decoded via bcn,
+// image.Paletted for P8).
+//
+// For mips read from a file with a ZIWS tag, the inverse of the swizzle is
+// applied and the result is NRGBA; see ImageWithOptions.
 func (m *MipMap) Image() (image.Image, error) {
 	return m.ImageWithOptions(nil)
 }
 
 // ImageWithOptions decodes the mipmap into an image.Image with optional BCn decode options.
+// Unless opts.RawChannels is set, the inverse of the file's ZIWS swizzle is applied
+// so channels come back as they were before encoding, followed by the CXAM max
+// color when opts.ApplyMaxColor is set.
 func (m *MipMap) ImageWithOptions(opts *DecodeOptions) (image.Image, error) {
+	return m.imageWork(opts, nil, 0)
+}
+
+// imageWork is ImageWithOptions observing work; mip is the level reported as progress.
+func (m *MipMap) imageWork(opts *DecodeOptions, work *workState, mip int) (image.Image, error) {
+	img, err := m.storedImage(opts, work, mip)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.RawChannels {
+		return img, nil
+	}
+
+	img = unswizzle(img, m.fileTag("ZIWS"))
+	if opts != nil && opts.ApplyMaxColor {
+		img = applyMaxColor(img, m.fileTag("CXAM"))
+	}
+
+	return img, nil
+}
+
+// fileTag returns the payload of the named tag of the file m was read from.
+func (m *MipMap) fileTag(name string) []byte {
+	if m.file == nil {
+		return nil
+	}
+
+	return m.file.tagMap()[name]
+}
+
+// storedImage decodes the mipmap with channels as stored in the file.
+func (m *MipMap) storedImage(opts *DecodeOptions, work *workState, mip int) (image.Image, error) {
 	w, h := int(m.Width), int(m.Height)
 	if w <= 0 || h <= 0 || len(m.Data) == 0 {
-		return nil, ErrInsufficientData
+		return nil, decodeError(StagePixels, m.Type, -1, ErrInsufficientData)
+	}
+	if err := work.err(); err != nil {
+		return nil, err
 	}
 
 	if isDXT(m.Type) {
 		bf := paxToBcnFormat(m.Type)
 		if bf == bcn.FormatUnknown {
-			return nil, ErrUnsupportedPixelFmt
+			return nil, decodeError(StageBCn, m.Type, -1, ErrUnsupportedPixelFmt)
 		}
 
 		var bcnOpts *bcn.DecodeOptions
@@ -137,19 +267,42 @@ func (m *MipMap) ImageWithOptions(opts *DecodeOptions) (image.Image, error) {
 			bcnOpts = opts.BCn
 		}
 
-		img, err := bcn.DecodeImageWithOptions(m.Data, w, h, bf, bcnOpts)
+		img, err := decodeBCn(m.Data, w, h, m.Type, bcnOpts, work, mip)
+		if werr := work.err(); werr != nil {
+			return nil, werr
+		}
 		if err != nil {
-			return nil, errors.Join(ErrDXTDecode, err)
+			return nil, decodeError(StageBCn, m.Type, -1, errors.Join(ErrDXTDecode, err))
+		}
+
+		if isPremultipliedDXT(m.Type) {
+			unpremultiplyAlpha(img)
 		}
 
 		return img, nil
 	}
 
+	if m.Type == PaxP8 {
+		var pal color.Palette
+		if m.file != nil {
+			pal = paletteColors(m.file.Palette)
+		}
+
+		img, err := decodePaletted(m.Data, w, h, pal)
+		if err != nil {
+			return nil, decodeError(StagePixels, m.Type, -1, err)
+		}
+
+		work.report(ProgressDecode, mip, 1)
+		return img, nil
+	}
+
 	img := image.NewNRGBA(image.Rect(0, 0, w, h))
 	if err := decodePixelFormat(m.Type, m.Data, w, h, img); err != nil {
-		return nil, err
+		return nil, decodeError(StagePixels, m.Type, -1, err)
 	}
 
+	work.report(ProgressDecode, mip, 1)
 	return img, nil
 }
 
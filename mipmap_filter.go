@@ -8,20 +8,51 @@ import (
 	"github.com/woozymasta/paa/texconfig"
 )
 
-// generateMipmapsWithFilter generates the mipmaps with the filter applied.
-func generateMipmapsWithFilter(img image.Image, useSRGB bool, filter texconfig.MipmapFilter) []image.Image {
-	mips := bcn.GenerateMipmaps(img, useSRGB)
-	if filter == texconfig.MipmapFilterDefault {
-		out := make([]image.Image, len(mips))
-		for i := range mips {
-			out[i] = mips[i]
+// mipGenOptions selects how generateMipmapsWithFilter builds the chain.
+type mipGenOptions struct {
+	// kernel downsamples each level from the previous one; ResampleDefault
+	// without wrap keeps the bcn 2x2 box chain.
+	kernel ResampleFilter
+	// filter is the TexConvert post-filter applied to levels below the base.
+	filter texconfig.MipmapFilter
+	// useSRGB filters RGB in linear light.
+	useSRGB bool
+	// wrap samples across the opposite edge (tileable textures).
+	wrap bool
+	// coverageRef, when non-zero, rescales alpha of each level so that the
+	// fraction of pixels with alpha above it matches the base level.
+	coverageRef uint8
+}
+
+// generateMipmapsWithFilter generates the full mip chain down to 1x1 with the
+// TexConvert filter applied. work, when not nil, is checked between the levels
+// of a kernel chain, which get ProgressMipmaps reports, and after the bcn chain.
+func generateMipmapsWithFilter(img image.Image, gen mipGenOptions, work *workState) ([]image.Image, error) {
+	var mips []*image.NRGBA
+	if gen.kernel == ResampleDefault && !gen.wrap {
+		mips = bcn.GenerateMipmaps(img, gen.useSRGB)
+	} else {
+		var err error
+		mips, err = generateMipmapsWithKernel(img, gen, work)
+		if err != nil {
+			return nil, err
 		}
+	}
+	if err := work.err(); err != nil {
+		return nil, err
+	}
 
-		return out
+	if gen.coverageRef != 0 && len(mips) > 1 {
+		target := alphaCoverage(mips[0], gen.coverageRef)
+		for level := 1; level < len(mips); level++ {
+			preserveAlphaCoverage(mips[level], gen.coverageRef, target)
+		}
 	}
 
-	for level := 1; level < len(mips); level++ {
-		applyMipmapFilter(mips[level], level, filter)
+	if gen.filter != texconfig.MipmapFilterDefault {
+		for level := 1; level < len(mips); level++ {
+			applyMipmapFilter(mips[level], level, gen.filter)
+		}
 	}
 
 	out := make([]image.Image, len(mips))
@@ -29,7 +60,114 @@ func generateMipmapsWithFilter(img image.Image, useSRGB bool, filter texconfig.M
 		out[i] = mips[i]
 	}
 
-	return out
+	return out, nil
+}
+
+// generateMipmapsWithKernel halves img down to 1x1, resampling each level from
+// the previous one with gen.kernel (box when unset). work is checked before each
+// level and progress is reported by the pixels of the levels built.
+func generateMipmapsWithKernel(img image.Image, gen mipGenOptions, work *workState) ([]*image.NRGBA, error) {
+	rp := resampleParams{filter: gen.kernel, wrap: gen.wrap, srgb: gen.useSRGB}
+	if rp.filter == ResampleDefault {
+		rp.filter = ResampleBox
+	}
+
+	base := toNRGBA(img)
+	mips := []*image.NRGBA{base}
+	w, h := base.Rect.Dx(), base.Rect.Dy()
+
+	prog := &stageProgress{work: work, stage: ProgressMipmaps}
+	for lw, lh := w, h; lw > 1 || lh > 1; {
+		lw, lh = max(lw>>1, 1), max(lh>>1, 1)
+		prog.total += lw * lh
+	}
+
+	for w > 1 || h > 1 {
+		if err := work.err(); err != nil {
+			return nil, err
+		}
+
+		w, h = max(w>>1, 1), max(h>>1, 1)
+		base = resampleWith(base, w, h, rp)
+		mips = append(mips, base)
+		prog.add(len(mips)-1, w*h)
+	}
+
+	return mips, nil
+}
+
+// alphaCoverage returns the fraction of pixels in img with alpha above ref.
+func alphaCoverage(img *image.NRGBA, ref uint8) float64 {
+	hist := alphaHistogram(img)
+	n, above := 0, 0
+	for a, c := range hist {
+		n += c
+		if a > int(ref) {
+			above += c
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+
+	return float64(above) / float64(n)
+}
+
+// preserveAlphaCoverage scales the alpha of img so that the fraction of pixels
+// with alpha above ref is as close as possible to coverage.
+//
+// It picks the alpha threshold t whose pass count best matches coverage and
+// maps t+1 just above ref and t just below it, so the count is met exactly.
+func preserveAlphaCoverage(img *image.NRGBA, ref uint8, coverage float64) {
+	hist := alphaHistogram(img)
+	n := 0
+	for _, c := range hist {
+		n += c
+	}
+	target := int(math.Round(coverage * float64(n)))
+
+	best, bestDiff := int(ref), n+1
+	above := n
+	for t := 0; t < 256; t++ {
+		above -= hist[t]
+		d := above - target
+		if d < 0 {
+			d = -d
+		}
+		if d < bestDiff {
+			best, bestDiff = t, d
+		}
+	}
+	if best == int(ref) {
+		return
+	}
+
+	scale := (float64(ref) + 0.5) / (float64(best) + 0.5)
+	var lut [256]uint8
+	for a := range lut {
+		lut[a] = uint8(math.Round(min(float64(a)*scale, 255)))
+	}
+
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := y * img.Stride
+		for x := 0; x < img.Rect.Dx(); x++ {
+			off := row + x*4 + 3
+			img.Pix[off] = lut[img.Pix[off]]
+		}
+	}
+}
+
+// alphaHistogram counts the pixels of img per alpha value.
+func alphaHistogram(img *image.NRGBA) [256]int {
+	var hist [256]int
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := y * img.Stride
+		for x := 0; x < img.Rect.Dx(); x++ {
+			hist[img.Pix[row+x*4+3]]++
+		}
+	}
+
+	return hist
 }
 
 // applyMipmapFilter applies the mipmap filter to the image.
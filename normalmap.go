@@ -4,6 +4,8 @@ import (
 	"image"
 	"image/color"
 	"math"
+
+	"github.com/woozymasta/paa/texconfig"
 )
 
 // swizzleDXT5NM is the SWIZTAGG payload for DXT5 normal maps (_nohq/_nofhq).
@@ -13,42 +15,81 @@ var swizzleDXT5NM = [4]byte{0x05, 0x04, 0x02, 0x03}
 // BIS wiki: *_nohq.paa swizzle = (Alpha negated in R, Red negated in A, G as-is, B as-is).
 // Engine: R_display=255-A_stored, G_display=G_stored, B_display=B_stored, A_display=255-R_stored.
 // We want (X,Y,Z,255) => store R=0, G=Y, B=Z, A=255-X.
+//
+// The vector is read from the alpha-premultiplied color (img.At(x, y).RGBA()),
+// so translucent pixels are darkened first; *image.NRGBA, *image.RGBA and
+// *image.YCbCr are read from their planes directly.
 func swizzleNormalMap(img image.Image) image.Image {
 	bounds := img.Bounds()
-	dst := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			r8 := uint8(r >> 8) //nolint:gosec // r is 0..65535
-			g8 := uint8(g >> 8) //nolint:gosec // g is 0..65535
-			b8 := uint8(b >> 8) //nolint:gosec // b is 0..65535
-
-			nx := (float64(r8)/255.0)*2.0 - 1.0
-			ny := (float64(g8)/255.0)*2.0 - 1.0
-
-			d := 1.0 - nx*nx - ny*ny
-			if d > 0 {
-				nzRec := math.Sqrt(d)
-				z8Rec := uint8(clamp01(nzRec*0.5+0.5) * 255)
-				if z8Rec > b8 {
-					b8 = z8Rec
+	dst := image.NewNRGBA(bounds)
+	w := bounds.Dx()
+
+	for y := 0; y < bounds.Dy(); y++ {
+		do := dst.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		out := dst.Pix[do : do+w*4]
+
+		switch src := img.(type) {
+		case *image.NRGBA:
+			so := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			for x, p := 0, src.Pix[so:so+w*4]; x < w*4; x += 4 {
+				r8, g8, b8 := p[x], p[x+1], p[x+2]
+				if a := p[x+3]; a != 255 {
+					r8, g8, b8 = premultiply8(r8, a), premultiply8(g8, a), premultiply8(b8, a)
 				}
+				swizzleNormal(out[x:x+4], r8, g8, b8)
+			}
+		case *image.RGBA:
+			so := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			for x, p := 0, src.Pix[so:so+w*4]; x < w*4; x += 4 {
+				swizzleNormal(out[x:x+4], p[x], p[x+1], p[x+2])
+			}
+		case *image.YCbCr:
+			for x := 0; x < w; x++ {
+				yi := src.YOffset(bounds.Min.X+x, bounds.Min.Y+y)
+				ci := src.COffset(bounds.Min.X+x, bounds.Min.Y+y)
+				r8, g8, b8 := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+				swizzleNormal(out[x*4:x*4+4], r8, g8, b8)
+			}
+		default:
+			for x := 0; x < w; x++ {
+				r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				swizzleNormal(out[x*4:x*4+4], uint8(r>>8), uint8(g>>8), uint8(b>>8)) //nolint:gosec // RGBA() is 0..65535
 			}
-			z8 := b8
-
-			dst.SetRGBA(x, y, color.RGBA{
-				R: 0,        // A_display = 255 - R = 255
-				G: g8,       // Y
-				B: z8,       // Z
-				A: 255 - r8, // X; R_display = 255 - A = X
-			})
 		}
 	}
 
 	return dst
 }
 
+// premultiply8 returns the 8-bit premultiplied channel v of alpha a, as
+// color.NRGBA.RGBA() computes it.
+func premultiply8(v, a uint8) uint8 {
+	v16 := uint32(v) * 0x101
+	return uint8(v16 * uint32(a) / 0xff >> 8) //nolint:gosec // G115: <= 0xffff >> 8
+}
+
+// swizzleNormal stores the nohq encoding of the normal (r8, g8, b8) in out:
+// R=0, G=Y, B=Z and A=255-X. Z is raised to the value reconstructed from X
+// and Y when the stored one is lower.
+func swizzleNormal(out []uint8, r8, g8, b8 uint8) {
+	nx := (float64(r8)/255.0)*2.0 - 1.0
+	ny := (float64(g8)/255.0)*2.0 - 1.0
+
+	d := 1.0 - nx*nx - ny*ny
+	if d > 0 {
+		nzRec := math.Sqrt(d)
+		z8Rec := uint8(clamp01(nzRec*0.5+0.5) * 255)
+		if z8Rec > b8 {
+			b8 = z8Rec
+		}
+	}
+
+	out[0] = 0        // A_display = 255 - R = 255
+	out[1] = g8       // Y
+	out[2] = b8       // Z
+	out[3] = 255 - r8 // X; R_display = 255 - A = X
+}
+
 // unswizzleNormalMap (Decode): PAA nohq (after DXT5 decode) -> Tangent Space RGB.
 // Stored R=0, G=Y, B=Z, A=255-X => X=255-A, Y=G, Z=B.
 func unswizzleNormalMap(img image.Image) image.Image {
@@ -134,106 +175,121 @@ func clamp01(f float64) float64 {
 	return f
 }
 
-// applySwizzlePayload applies a SWIZTAGG payload to an image and returns an NRGBA image.
-// Tag bytes are in A, R, G, B order. Each byte encodes:
+// unswizzle returns img with the inverse of the ZIWS tag applied, undoing the
+// channel swizzle the encoder applied to the payload. The nohq tag goes through
+// unswizzleNormalMap, which also renormalizes the vectors. Identity, missing
+// and malformed tags return img unchanged.
+func unswizzle(img image.Image, tag []byte) image.Image {
+	if len(tag) != 4 {
+		return img
+	}
+
+	t := [4]byte(tag)
+	if t == swizzleDXT5NM {
+		return unswizzleNormalMap(img)
+	}
+
+	swz, err := texconfig.ParseZIWSTag(t)
+	if err != nil || swz.IsIdentity() {
+		return img
+	}
+
+	return applyInverseSwizzle(img, inverseSwizzle(swz))
+}
+
+// channelSource tells where an output channel comes from: stored channel src
+// (NRGBA index), optionally inverted, or the constant value when src is -1.
+type channelSource struct {
+	src    int
+	value  uint8
+	invert bool
+}
+
+// inverseSwizzle inverts swz, where stored channel X = swz.X(original).
 //
-//	bit3: force 0xFF
-//	bit2: negate
-//	bit1-0: source channel (00=A, 01=R, 10=G, 11=B)
-func applySwizzlePayload(img image.Image, tag [4]byte) *image.NRGBA {
-	src := toNRGBA(img)
-	b := src.Bounds()
-	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+// Each original channel is read back from a stored channel that holds it,
+// preferring the channel's own slot. Channels the swizzle dropped cannot be
+// recovered: they take the constant stored in their own slot if there is one,
+// otherwise 255 for alpha and 0 for color.
+func inverseSwizzle(swz texconfig.ChannelSwizzle) [4]channelSource {
+	exprs := [4]texconfig.SwizzleExpr{swz.R, swz.G, swz.B, swz.A}
+	defaults := [4]texconfig.SwizzleSource{texconfig.SwizzleR, texconfig.SwizzleG, texconfig.SwizzleB, texconfig.SwizzleA}
+
+	var inv [4]channelSource
+	found := [4]bool{}
+	for x, e := range exprs {
+		if !e.Valid {
+			e = texconfig.SwizzleExpr{Valid: true, Source: defaults[x]}
+		}
+		if e.IsConst {
+			continue
+		}
 
-	get := func(off int, sel uint8) uint8 {
-		switch sel & 0x3 {
-		case 0:
-			return src.Pix[off+3] // A
-		case 1:
-			return src.Pix[off+0] // R
-		case 2:
-			return src.Pix[off+1] // G
-		default:
-			return src.Pix[off+2] // B
+		c := nrgbaIndex(e.Source)
+		if c < 0 || (found[c] && inv[c].src == c) {
+			continue
+		}
+		if !found[c] || x == c {
+			inv[c] = channelSource{src: x, invert: e.Invert}
+			found[c] = true
 		}
 	}
 
-	for y := 0; y < b.Dy(); y++ {
-		srow := y * src.Stride
-		drow := y * dst.Stride
-		for x := 0; x < b.Dx(); x++ {
-			so := srow + x*4
-			do := drow + x*4
-
-			for i := 0; i < 4; i++ {
-				t := tag[i]
-				var v uint8
-				if (t & 0x08) != 0 {
-					v = 0xFF
-				} else {
-					v = get(so, t)
-					if (t & 0x04) != 0 {
-						v = 255 - v
-					}
-				}
+	for c := range inv {
+		if found[c] {
+			continue
+		}
 
-				switch i {
-				case 0:
-					dst.Pix[do+3] = v // A
-				case 1:
-					dst.Pix[do+0] = v // R
-				case 2:
-					dst.Pix[do+1] = v // G
-				case 3:
-					dst.Pix[do+2] = v // B
-				}
-			}
+		inv[c] = channelSource{src: -1}
+		switch e := exprs[c]; {
+		case e.Valid && e.IsConst:
+			inv[c].value = e.ConstValue
+		case c == 3:
+			inv[c].value = 255
 		}
 	}
 
-	return dst
+	return inv
 }
 
-// applyADSHQSwizzle decodes ADSHQ-style swizzle (tag 02 09 03 09) into a viewable image.
-// This maps A -> G (ambient), copies G into B, sets R to 0, and preserves alpha.
-func applyADSHQSwizzle(img image.Image) *image.NRGBA {
+// nrgbaIndex returns the NRGBA byte index of a swizzle source, -1 if unknown.
+func nrgbaIndex(s texconfig.SwizzleSource) int {
+	switch s {
+	case texconfig.SwizzleR:
+		return 0
+	case texconfig.SwizzleG:
+		return 1
+	case texconfig.SwizzleB:
+		return 2
+	case texconfig.SwizzleA:
+		return 3
+	default:
+		return -1
+	}
+}
+
+// applyInverseSwizzle builds a new NRGBA image with channels taken from inv.
+func applyInverseSwizzle(img image.Image, inv [4]channelSource) *image.NRGBA {
 	src := toNRGBA(img)
 	b := src.Bounds()
 	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
 	for y := 0; y < b.Dy(); y++ {
-		srow := y * src.Stride
-		drow := y * dst.Stride
-		for x := 0; x < b.Dx(); x++ {
-			so := srow + x*4
-			do := drow + x*4
-			a := src.Pix[so+3]
-			dst.Pix[do+0] = 0 // R
-			dst.Pix[do+1] = a // G <- A
-			dst.Pix[do+2] = a // B <- G
-			dst.Pix[do+3] = a // A
-		}
-	}
-
-	return dst
-}
+		so := src.PixOffset(b.Min.X, b.Min.Y+y)
+		do := y * dst.Stride
+		for x := 0; x < b.Dx(); x, so, do = x+1, so+4, do+4 {
+			for c, cs := range inv {
+				v := cs.value
+				if cs.src >= 0 {
+					v = src.Pix[so+cs.src]
+					if cs.invert {
+						v = 255 - v
+					}
+				}
 
-// toNRGBA converts an image.Image to *image.NRGBA without premultiplication.
-func toNRGBA(img image.Image) *image.NRGBA {
-	if n, ok := img.(*image.NRGBA); ok {
-		return n
-	}
-	b := img.Bounds()
-	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
-			off := (y-b.Min.Y)*out.Stride + (x-b.Min.X)*4
-			out.Pix[off+0] = c.R
-			out.Pix[off+1] = c.G
-			out.Pix[off+2] = c.B
-			out.Pix[off+3] = c.A
+				dst.Pix[do+c] = v
+			}
 		}
 	}
 
-	return out
+	return dst
 }
@@ -10,7 +10,11 @@ High-level usage:
 
 File structure (simplified):
 
-	[2 bytes pax type] [GGAT tags...] [mipmap blocks...] [0,0,0,0,0,0]
+	[2 bytes pax type] [GGAT tags...] [palette] [mipmap blocks...] [0,0,0,0,0,0]
+
+The palette is a uint16 triplet count followed by BGR triplets; it is empty
+(count 0) for all formats except indexed P8 textures, which also omit the pax
+type magic (legacy OFP/Arma 1 PAC files may omit the tags as well).
 
 Tags are optional in theory but always present in practice. Tag names are stored
 as four-byte identifiers (e.g. "CGVA", "CXAM", "GALF", "ZIWS", "SFFO") and map to
@@ -22,8 +26,9 @@ Each mipmap block is:
 
 	uint16 width, uint16 height, 3-byte size, [size bytes payload]
 
-Some files include a trailing “dummy” mipmap (width=0,height=0), but decoding
-uses SFFO as the authoritative list of mip offsets.
+Some files include a trailing “dummy” mipmap (width=0,height=0). Decoding uses
+SFFO as the authoritative list of mip offsets and falls back to walking the mip
+chain up to that terminator when SFFO is missing or broken.
 
 DXT payload size is the *encoded* size (BC1/BC3 blocks), not width*height*4.
 For DXT1: ((w+3)/4)*((h+3)/4)*8
@@ -39,6 +44,7 @@ SFFO (offset table):
 SFFO contains 16 uint32 offsets to mipmap blocks, relative to file start.
 Only as many entries as actual mip levels are filled; remaining entries are 0.
 The engine can derive offsets without SFFO, but BI tools always write it.
+DecodePAA and DecodeMetadata do the same for SFFO-less files (DerivedOffsets).
 
 Normal maps (_nohq):
 Arma stores tangent-space normal maps in DXT5 with a swizzle tag:
@@ -54,9 +60,11 @@ The runtime reconstructs display channels as:
 	X = 255 - A, Y = G, Z = B
 
 This package can apply the swizzle on encode (e.g. NormalMapSwizzle or hint‑driven
-swizzle) and will unswizzle on decode when the ZIWS tag matches. For some hints
-we emit ZIWS but intentionally keep payload unswizzled to avoid double‑swizzle
-in external tools.
+swizzle). On decode, every mip of every pax type gets the inverse of its ZIWS
+tag applied (the nohq tag also renormalizes the normals); channels the swizzle
+dropped cannot be recovered. DecodeOptions.RawChannels returns stored channels
+instead. For some hints we emit ZIWS but intentionally keep payload unswizzled
+to avoid double‑swizzle in external tools.
 
 Important caveat: some external viewers ignore SWIZTAGG and display raw
 channels, which makes _nohq appear incorrect even when the data is valid.
@@ -91,14 +99,25 @@ package paa
 type PAA struct {
 	// Tag identifiers (e.g. "CGVA", "CXAM", "GALF", "ZIWS", "SFFO") are stored as-is.
 	// This package writes tags in the canonical order used by BI tools: CGVA, CXAM, GALF (optional), ZIWS (optional), SFFO.
+	// When Tags is set, Taggs is only a view of it (the last payload of each name)
+	// and direct edits to Taggs are ignored; use SetTag and DeleteTag to update both.
 	Taggs map[string][]byte
 
+	// Tags lists GGAT entries in file order, including unknown tags and their offsets.
+	// When set, it is the authoritative tag list: PAA.WriteTo and the tag accessors use it.
+	Tags TagList
+
 	// Mipmaps are stored in the file in order.
 	MipMaps []*MipMap
 
-	// Palette is stored in the file.
+	// Palette holds raw palette triplets in BGR order, as stored in the file.
+	// It is only non-empty for indexed (PaxP8) textures.
 	Palette []byte
 
 	// Type is the PaxType of the texture.
 	Type PaxType
+
+	// DerivedOffsets is true when SFFO was missing or unusable and mip offsets
+	// were derived by walking the mip chain.
+	DerivedOffsets bool
 }
@@ -2,13 +2,18 @@ package paa
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -101,6 +106,71 @@ func TestDecodeConfig(t *testing.T) {
 	}
 }
 
+func TestDecodeConfigColorModel(t *testing.T) {
+	type onlyReader struct{ io.Reader }
+	for _, tc := range []struct {
+		path  string
+		model color.Model
+	}{
+		{path: "test_88.paa", model: color.NRGBAModel},
+		{path: "test_co.paa", model: color.NRGBAModel},
+		{path: "test_1555.paa", model: color.NRGBAModel},
+	} {
+		raw, err := os.ReadFile(filepath.Join("testdata", tc.path))
+		if err != nil {
+			t.Fatalf("read fixture: %v", err)
+		}
+
+		full, err := DecodePAA(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s DecodePAA: %v", tc.path, err)
+		}
+
+		cfg, err := DecodeConfig(onlyReader{Reader: bytes.NewReader(raw)})
+		if err != nil {
+			t.Fatalf("%s DecodeConfig: %v", tc.path, err)
+		}
+		if cfg.Width != int(full.MipMaps[0].Width) || cfg.Height != int(full.MipMaps[0].Height) {
+			t.Errorf("%s DecodeConfig = %dx%d, want %dx%d", tc.path, cfg.Width, cfg.Height, full.MipMaps[0].Width, full.MipMaps[0].Height)
+		}
+		if cfg.ColorModel != tc.model {
+			t.Errorf("%s ColorModel=%T, want %T", tc.path, cfg.ColorModel, tc.model)
+		}
+		decoded, err := Decode(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s Decode: %v", tc.path, err)
+		}
+		if decoded.ColorModel() != cfg.ColorModel {
+			t.Errorf("%s decoded model differs from DecodeConfig", tc.path)
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i / 32 * 64) //nolint:gosec // G115
+	}
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, img, &EncodeOptions{Type: PaxP8}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("P8 DecodeConfig: %v", err)
+	}
+	pal, ok := cfg.ColorModel.(color.Palette)
+	if !ok || len(pal) == 0 {
+		t.Fatalf("P8 ColorModel=%T, want non-empty color.Palette", cfg.ColorModel)
+	}
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("P8 Decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.ColorModel(), cfg.ColorModel) {
+		t.Errorf("P8 decoded palette differs from DecodeConfig")
+	}
+}
+
 func TestDecodePAA_InvalidMagic(t *testing.T) {
 	_, err := DecodePAA(bytes.NewReader([]byte{0, 0}))
 	if err == nil {
@@ -112,7 +182,7 @@ func TestDecodePAA_InvalidMagic(t *testing.T) {
 }
 
 func TestDecodePAA_NoSeeker(t *testing.T) {
-	// Reader that doesn't implement Seeker: DecodePAA should ReadAll and use bytes.Reader.
+	// Reader that doesn't implement Seeker: Decode reads it forward-only.
 	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
 	var buf bytes.Buffer
 	if err := Encode(&buf, img); err != nil {
@@ -415,46 +485,1614 @@ func TestUnsupportedFormats(t *testing.T) {
 	}
 }
 
-func assertSFFOOffsets(t *testing.T, data []byte, taggs map[string][]byte) {
-	t.Helper()
-	sffo := taggs["SFFO"]
-	if len(sffo) < 4 {
-		t.Fatalf("missing SFFO")
+func TestWriteToRoundTripFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "test_*.paa"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testdata/test_*.paa files found: %v", err)
 	}
-	last := uint32(0)
-	for i := 0; i+4 <= len(sffo); i += 4 {
-		v := binary.LittleEndian.Uint32(sffo[i : i+4])
-		if v == 0 {
-			continue
+
+	for _, path := range files {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+
+			src, err := DecodePAA(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("DecodePAA: %v", err)
+			}
+
+			var buf bytes.Buffer
+			n, err := src.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("WriteTo: %v", err)
+			}
+			if n != int64(buf.Len()) {
+				t.Fatalf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+			}
+			assertSFFOOffsets(t, buf.Bytes(), parseTagg(buf.Bytes()))
+
+			dst, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("DecodePAA(rewritten): %v", err)
+			}
+
+			if dst.Type != src.Type {
+				t.Fatalf("type mismatch: %d != %d", dst.Type, src.Type)
+			}
+			for name, data := range src.Taggs {
+				if name == "SFFO" {
+					continue
+				}
+				if !bytes.Equal(dst.Taggs[name], data) {
+					t.Fatalf("tag %s mismatch: %v != %v", name, dst.Taggs[name], data)
+				}
+			}
+			if len(dst.MipMaps) != len(src.MipMaps) {
+				t.Fatalf("mip count mismatch: %d != %d", len(dst.MipMaps), len(src.MipMaps))
+			}
+			for i := range src.MipMaps {
+				if !bytes.Equal(dst.MipMaps[i].Data, src.MipMaps[i].Data) {
+					t.Fatalf("mip %d payload mismatch", i)
+				}
+				if dst.MipMaps[i].Compressed != src.MipMaps[i].Compressed {
+					t.Fatalf("mip %d compression mismatch", i)
+				}
+			}
+
+			// Stored payloads are written back, so files are reproduced bit-for-bit.
+			if !bytes.Equal(raw, buf.Bytes()) {
+				t.Fatalf("file not reproduced bit-for-bit")
+			}
+
+			// Payloads set through SetData or replaced are compressed again instead.
+			src.MipMaps[0].Data[0] ^= 0xFF
+			src.MipMaps[0].SetData(src.MipMaps[0].Data)
+			last := src.MipMaps[len(src.MipMaps)-1]
+			last.Data = append([]byte(nil), last.Data...)
+			last.Data[0] ^= 0xFF
+			buf.Reset()
+			if _, err := src.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo(edited): %v", err)
+			}
+			dst, err = DecodePAA(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("DecodePAA(edited): %v", err)
+			}
+			if !bytes.Equal(dst.MipMaps[0].Data, src.MipMaps[0].Data) {
+				t.Fatalf("edited mip 0 payload not written")
+			}
+			if !bytes.Equal(dst.MipMaps[len(dst.MipMaps)-1].Data, last.Data) {
+				t.Fatalf("replaced last mip payload not written")
+			}
+		})
+	}
+}
+
+func TestEncodePAAPatchTag(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "test_co.paa"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	p, err := DecodePAA(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	p.SetTag("GALF", []byte{2, 0, 0, 0})
+
+	var buf bytes.Buffer
+	if err := EncodePAA(&buf, p); err != nil {
+		t.Fatalf("EncodePAA: %v", err)
+	}
+
+	taggs := parseTagg(buf.Bytes())
+	if !bytes.Equal(taggs["GALF"], []byte{2, 0, 0, 0}) {
+		t.Fatalf("GALF = %v, want 02 00 00 00", taggs["GALF"])
+	}
+	assertSFFOOffsets(t, buf.Bytes(), taggs)
+
+	got, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA(patched): %v", err)
+	}
+	for i := range p.MipMaps {
+		if !bytes.Equal(got.MipMaps[i].Data, p.MipMaps[i].Data) {
+			t.Fatalf("mip %d payload changed", i)
 		}
-		if v <= last {
-			t.Fatalf("SFFO offsets not increasing: %d then %d", last, v)
+	}
+}
+
+func TestTagOrderAndUnknownTags(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+
+	opts := &EncodeOptions{
+		Type:            PaxDXT1,
+		GenerateMipmaps: ptrBool(false),
+		ExtraTags: []Tag{
+			{Name: "TSET", Data: []byte{1, 2, 3}},
+			{Name: "SFFO", Data: []byte{9, 9, 9, 9}},
+			{Name: "CXAM", Data: []byte{1, 2, 3, 4}},
+			{Name: "TSET", Data: []byte{4}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, img, opts); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+
+	p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+
+	wantOrder := []string{"CGVA", "CXAM", "TSET", "TSET", "SFFO"}
+	if len(p.Tags) != len(wantOrder) {
+		t.Fatalf("tag count=%d, want %d", len(p.Tags), len(wantOrder))
+	}
+	offset := int64(2)
+	for i, name := range wantOrder {
+		if p.Tags[i].Name != name {
+			t.Fatalf("tag[%d]=%s, want %s", i, p.Tags[i].Name, name)
 		}
-		if int(v) >= len(data) {
-			t.Fatalf("SFFO offset out of bounds: %d >= %d", v, len(data))
+		if p.Tags[i].Offset != offset {
+			t.Fatalf("tag[%d] offset=%d, want %d", i, p.Tags[i].Offset, offset)
 		}
-		last = v
+		if string(buf.Bytes()[offset:offset+4]) != "GGAT" {
+			t.Fatalf("tag[%d] offset does not point at GGAT", i)
+		}
+		offset += 12 + int64(len(p.Tags[i].Data))
+	}
+	if !bytes.Equal(p.Tags[1].Data, []byte{1, 2, 3, 4}) {
+		t.Fatalf("CXAM = %v, want the extra payload", p.Tags[1].Data)
+	}
+	if unknown := p.Tags.Unknown(); len(unknown) != 2 || !bytes.Equal(unknown[1].Data, []byte{4}) {
+		t.Fatalf("unknown tags = %v, want both TSET in order", unknown)
+	}
+
+	meta, err := DecodeMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeMetadata: %v", err)
+	}
+	if len(meta.Tags) != len(p.Tags) || meta.Tags[2].Name != "TSET" {
+		t.Fatalf("metadata tags mismatch: %v", meta.Tags)
+	}
+
+	// New tags go before SFFO and existing order is kept. Tags is authoritative:
+	// a tag appended only to it is written, as are edits to its payloads.
+	p.SetTag("GALF", []byte{1, 0, 0, 0})
+	p.Tags = append(p.Tags[:len(p.Tags)-1], Tag{Name: "ZZZZ", Data: []byte{7}}, p.Tags[len(p.Tags)-1])
+	p.Tags[1].Data = []byte{5, 6, 7, 8}
+	p.Taggs["QQQQ"] = []byte{9}
+	var out bytes.Buffer
+	if err := EncodePAA(&out, p); err != nil {
+		t.Fatalf("EncodePAA: %v", err)
+	}
+
+	got, err := DecodePAA(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA(rewritten): %v", err)
+	}
+	wantOrder = []string{"CGVA", "CXAM", "TSET", "TSET", "GALF", "ZZZZ", "SFFO"}
+	if len(got.Tags) != len(wantOrder) {
+		t.Fatalf("rewritten tags = %v, want %v", got.Tags, wantOrder)
+	}
+	for i, name := range wantOrder {
+		if got.Tags[i].Name != name {
+			t.Fatalf("rewritten tag order = %v, want %v", got.Tags, wantOrder)
+		}
+	}
+	if !bytes.Equal(got.Tags[5].Data, []byte{7}) {
+		t.Errorf("ZZZZ = % x, want 07", got.Tags[5].Data)
 	}
+	if c, _ := got.MaxColor(); c != (color.NRGBA{R: 7, G: 6, B: 5, A: 8}) {
+		t.Errorf("edited CXAM = %v, want the Tags payload", c)
+	}
+	assertSFFOOffsets(t, out.Bytes(), parseTagg(out.Bytes()))
 }
 
-func firstMipPayload(data []byte) (payload []byte, w, h int, lzo bool, err error) {
-	taggs := parseTagg(data)
-	off := firstMipOffset(taggs)
-	if off == 0 || off+7 > len(data) {
-		return nil, 0, 0, false, ErrInsufficientData
+func TestP8PaletteRoundTrip(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	colors := []color.NRGBA{
+		{R: 255, A: 255},
+		{G: 255, A: 255},
+		{B: 255, A: 255},
+		{R: 10, G: 20, B: 30, A: 255},
+	}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, colors[(x/4+y/4)%len(colors)])
+		}
 	}
-	rawW := binary.LittleEndian.Uint16(data[off : off+2])
-	rawH := binary.LittleEndian.Uint16(data[off+2 : off+4])
-	lzo = (rawW & 0x8000) != 0
-	w = int(rawW & 0x7FFF)
-	h = int(rawH)
-	size := int(data[off+4]) | int(data[off+5])<<8 | int(data[off+6])<<16
-	if off+7+size > len(data) {
-		return nil, 0, 0, false, ErrInsufficientData
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, img, &EncodeOptions{Type: PaxP8}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	if string(buf.Bytes()[:4]) != "GGAT" {
+		t.Fatalf("P8 file must start with tags, got % x", buf.Bytes()[:4])
+	}
+
+	p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	if p.Type != PaxP8 {
+		t.Fatalf("type=%d, want PaxP8", p.Type)
+	}
+	if len(p.Palette) != len(colors)*3 {
+		t.Fatalf("palette size=%d, want %d", len(p.Palette), len(colors)*3)
+	}
+	if len(p.MipMaps) != 5 { // 16,8,4,2,1
+		t.Fatalf("mip count=%d, want 5", len(p.MipMaps))
+	}
+
+	meta, err := DecodeMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeMetadata: %v", err)
+	}
+	if !bytes.Equal(meta.Palette, p.Palette) {
+		t.Fatalf("metadata palette mismatch")
+	}
+
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	pal, ok := decoded.(*image.Paletted)
+	if !ok {
+		t.Fatalf("decoded type=%T, want *image.Paletted", decoded)
+	}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			got := color.NRGBAModel.Convert(pal.At(x, y)).(color.NRGBA)
+			if got != img.NRGBAAt(x, y) {
+				t.Fatalf("pixel (%d,%d)=%v, want %v", x, y, got, img.NRGBAAt(x, y))
+			}
+		}
 	}
-	return data[off+7 : off+7+size], w, h, lzo, nil
 }
 
-func ptrBool(v bool) *bool {
-	return &v
+func TestP8PACWithoutTags(t *testing.T) {
+	// Legacy PAC: palette count first, no magic, no tags, no SFFO.
+	data := []byte{
+		2, 0, // palette triplets
+		0, 0, 255, // red (BGR)
+		255, 0, 0, // blue (BGR)
+		2, 0, 1, 0, 2, 0, 0, // 2x1 mip, 2 bytes
+		1, 0, // indices
+		0, 0, 0, 0, 0, 0, // terminator
+	}
+
+	p, err := DecodePAA(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	if p.Type != PaxP8 || !p.DerivedOffsets || len(p.MipMaps) != 1 {
+		t.Fatalf("type=%d derived=%v mips=%d, want P8 with 1 derived mip", p.Type, p.DerivedOffsets, len(p.MipMaps))
+	}
+
+	img, err := p.MipMaps[0].Image()
+	if err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	if got := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA); got != (color.NRGBA{B: 255, A: 255}) {
+		t.Fatalf("pixel(0,0)=%v, want blue", got)
+	}
+	if got := color.NRGBAModel.Convert(img.At(1, 0)).(color.NRGBA); got != (color.NRGBA{R: 255, A: 255}) {
+		t.Fatalf("pixel(1,0)=%v, want red", got)
+	}
+
+	// Data that merely starts with a small count is not a palette file.
+	garbage := make([]byte, 64)
+	for i := range garbage {
+		garbage[i] = byte(i*37 + 11)
+	}
+	garbage[0], garbage[1] = 3, 0
+	for name, data := range map[string][]byte{
+		"count too large":   {0x34, 0x12},
+		"palette truncated": {2, 0, 1, 2, 3},
+		"garbage mip":       garbage,
+		"no mip":            data[:2+6],
+		"zero mip size":     append(append([]byte{}, data[:2+6]...), 2, 0, 1, 0, 0, 0, 0),
+	} {
+		if _, err := DecodePAA(bytes.NewReader(data)); !errors.Is(err, ErrInvalidMagic) {
+			t.Errorf("%s: err = %v, want ErrInvalidMagic", name, err)
+		}
+		if _, err := DecodeMetadata(bytes.NewReader(data)); !errors.Is(err, ErrInvalidMagic) {
+			t.Errorf("%s: DecodeMetadata err = %v, want ErrInvalidMagic", name, err)
+		}
+	}
+}
+
+func TestDecodeWithoutSFFO(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, img, &EncodeOptions{Type: PaxDXT5, UseLZO: true}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	orig := buf.Bytes()
+
+	want, err := DecodePAA(bytes.NewReader(orig))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	if want.DerivedOffsets {
+		t.Fatalf("DerivedOffsets set for a file with valid SFFO")
+	}
+
+	sffoPos := bytes.Index(orig, []byte("GGATSFFO"))
+	cases := map[string]func(data []byte){
+		"renamed": func(data []byte) { copy(data[sffoPos+4:], "XXXX") },
+		"zeroed": func(data []byte) {
+			for i := sffoPos + 12; i < sffoPos+12+64; i++ {
+				data[i] = 0
+			}
+		},
+		"past_eof": func(data []byte) {
+			binary.LittleEndian.PutUint32(data[sffoPos+12:], uint32(len(data)+100))
+		},
+	}
+
+	for name, mutate := range cases {
+		mutate := mutate
+		t.Run(name, func(t *testing.T) {
+			data := append([]byte(nil), orig...)
+			mutate(data)
+
+			got, err := DecodePAA(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodePAA: %v", err)
+			}
+			if !got.DerivedOffsets {
+				t.Fatalf("DerivedOffsets not set")
+			}
+			if len(got.MipMaps) != len(want.MipMaps) {
+				t.Fatalf("mip count=%d, want %d", len(got.MipMaps), len(want.MipMaps))
+			}
+			for i := range want.MipMaps {
+				if !bytes.Equal(got.MipMaps[i].Data, want.MipMaps[i].Data) {
+					t.Fatalf("mip %d payload mismatch", i)
+				}
+			}
+
+			meta, err := DecodeMetadata(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodeMetadata: %v", err)
+			}
+			if !meta.DerivedOffsets || len(meta.MipHeaders) != len(want.MipMaps) {
+				t.Fatalf("metadata derived=%v mips=%d, want %d", meta.DerivedOffsets, len(meta.MipHeaders), len(want.MipMaps))
+			}
+			wantOffsets := nonZeroSFFOOffsets(want.Taggs["SFFO"])
+			for i, mh := range meta.MipHeaders {
+				if mh.Offset != wantOffsets[i] {
+					t.Fatalf("mip %d offset=%d, want %d", i, mh.Offset, wantOffsets[i])
+				}
+			}
+		})
+	}
+
+	// Without SFFO and without any mip block, there is nothing to fall back to.
+	empty := append([]byte(nil), orig[:firstMipOffset(parseTagg(orig))]...)
+	copy(empty[sffoPos+4:], "XXXX")
+	empty = append(empty, 0, 0, 0, 0)
+	if _, err := DecodePAA(bytes.NewReader(empty)); !errors.Is(err, ErrNoMipmaps) || !errors.Is(err, ErrMissingSFFO) {
+		t.Fatalf("DecodePAA(no mips) err = %v, want ErrNoMipmaps and ErrMissingSFFO", err)
+	}
+	if _, err := DecodeMetadata(bytes.NewReader(empty)); !errors.Is(err, ErrNoMipmaps) {
+		t.Fatalf("DecodeMetadata(no mips) err = %v, want ErrNoMipmaps", err)
+	}
+}
+
+func TestP8FromTexConfigHint(t *testing.T) {
+	cfg := texconfig.TexConvertConfig{Hints: []texconfig.TextureHint{
+		{ClassName: "indexed", Pattern: "*_p8.*", Format: texconfig.TexFormatP8},
+	}}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithTexConfig(&buf, img, "texture_p8.paa", cfg); err != nil {
+		t.Fatalf("EncodeWithTexConfig: %v", err)
+	}
+
+	p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	if p.Type != PaxP8 || len(p.Palette) == 0 || len(p.Palette) > 256*3 {
+		t.Fatalf("type=%d palette=%d, want P8 with palette", p.Type, len(p.Palette))
+	}
+}
+
+func TestEncodeDXT2DXT3DXT4(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 200, G: 100, B: 40, A: 136})
+		}
+	}
+
+	for _, pax := range []PaxType{PaxDXT2, PaxDXT3, PaxDXT4} {
+		pax := pax
+		t.Run(fmt.Sprintf("pax_%d", pax), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeWithOptions(&buf, img, &EncodeOptions{Type: pax, UseLZO: true}); err != nil {
+				t.Fatalf("EncodeWithOptions: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes()[:2], pax.Bytes()) {
+				t.Fatalf("magic = % x, want % x", buf.Bytes()[:2], pax.Bytes())
+			}
+
+			p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("DecodePAA: %v", err)
+			}
+			if p.Type != pax {
+				t.Fatalf("type=%d, want %d", p.Type, pax)
+			}
+			if !p.MipMaps[0].Compressed {
+				t.Fatalf("expected LZO on uniform mip")
+			}
+			if len(p.MipMaps[0].Data) != expectedMipSize(pax, 16, 16) {
+				t.Fatalf("mip size=%d, want %d", len(p.MipMaps[0].Data), expectedMipSize(pax, 16, 16))
+			}
+
+			decoded, err := p.MipMaps[0].Image()
+			if err != nil {
+				t.Fatalf("Image: %v", err)
+			}
+			got := color.NRGBAModel.Convert(decoded.At(5, 5)).(color.NRGBA)
+			want := img.NRGBAAt(5, 5)
+			if absDiff(got.R, want.R) > 24 || absDiff(got.G, want.G) > 24 || absDiff(got.B, want.B) > 24 || absDiff(got.A, want.A) > 17 {
+				t.Fatalf("pixel=%v, want ~%v", got, want)
+			}
+		})
+	}
+}
+
+func TestDXT3FromTexConfigHint(t *testing.T) {
+	cfg := texconfig.TexConvertConfig{Hints: []texconfig.TextureHint{
+		{ClassName: "ui", Pattern: "*_ui.*", Format: texconfig.TexFormatDXT3},
+	}}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithTexConfig(&buf, img, "button_ui.paa", cfg); err != nil {
+		t.Fatalf("EncodeWithTexConfig: %v", err)
+	}
+	if pax, _ := PaxTypeFromBytes(buf.Bytes()[:2]); pax != PaxDXT3 {
+		t.Fatalf("pax=%d, want PaxDXT3", pax)
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestStreamDecoderFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "test_*.paa"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testdata/test_*.paa files found: %v", err)
+	}
+
+	type onlyReader struct{ io.Reader }
+	for _, path := range files {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+
+			full, err := DecodePAA(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("DecodePAA: %v", err)
+			}
+
+			streamed, err := DecodePAAStream(onlyReader{Reader: bytes.NewReader(raw)})
+			if err != nil {
+				t.Fatalf("DecodePAAStream: %v", err)
+			}
+
+			if streamed.Type != full.Type || len(streamed.Tags) != len(full.Tags) {
+				t.Fatalf("header mismatch")
+			}
+			if len(streamed.MipMaps) != len(full.MipMaps) {
+				t.Fatalf("mip count=%d, want %d", len(streamed.MipMaps), len(full.MipMaps))
+			}
+			for i := range full.MipMaps {
+				if !bytes.Equal(streamed.MipMaps[i].Data, full.MipMaps[i].Data) {
+					t.Fatalf("mip %d payload mismatch", i)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamDecoderSFFOMismatch(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, img, &EncodeOptions{Type: PaxDXT1}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+
+	data := buf.Bytes()
+	taggs := parseTagg(data)
+	off := firstMipOffset(taggs)
+	sffoPos := bytes.Index(data, []byte("GGATSFFO")) + 12
+	binary.LittleEndian.PutUint32(data[sffoPos:], uint32(off+1))
+
+	// The mips are still read sequentially; the mismatch is only reported.
+	p, err := DecodePAAStream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodePAAStream: %v", err)
+	}
+	if !p.DerivedOffsets || len(p.MipMaps) == 0 {
+		t.Fatalf("derived=%v mips=%d, want derived offsets and mips", p.DerivedOffsets, len(p.MipMaps))
+	}
+	if _, err := Decode(struct{ io.Reader }{bytes.NewReader(data)}); err != nil {
+		t.Fatalf("Decode(forward-only): %v", err)
+	}
+
+	d, err := NewStreamDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewStreamDecoder: %v", err)
+	}
+	for err == nil {
+		err = d.Skip()
+	}
+	if err != io.EOF {
+		t.Fatalf("Skip: %v", err)
+	}
+	if !errors.Is(d.SFFOError(), ErrSFFOMismatch) {
+		t.Fatalf("SFFOError = %v, want ErrSFFOMismatch", d.SFFOError())
+	}
+
+	// Without mip blocks both decoders fail the same way.
+	empty := append(append([]byte(nil), data[:off]...), make([]byte, 4)...)
+	if _, err := DecodePAAStream(bytes.NewReader(empty)); !errors.Is(err, ErrNoMipmaps) {
+		t.Errorf("DecodePAAStream(no mips) err=%v, want ErrNoMipmaps", err)
+	}
+	if _, err := DecodePAA(bytes.NewReader(empty)); !errors.Is(err, ErrNoMipmaps) {
+		t.Errorf("DecodePAA(no mips) err=%v, want ErrNoMipmaps", err)
+	}
+}
+
+func assertSFFOOffsets(t *testing.T, data []byte, taggs map[string][]byte) {
+	t.Helper()
+	sffo := taggs["SFFO"]
+	if len(sffo) < 4 {
+		t.Fatalf("missing SFFO")
+	}
+	last := uint32(0)
+	for i := 0; i+4 <= len(sffo); i += 4 {
+		v := binary.LittleEndian.Uint32(sffo[i : i+4])
+		if v == 0 {
+			continue
+		}
+		if v <= last {
+			t.Fatalf("SFFO offsets not increasing: %d then %d", last, v)
+		}
+		if int(v) >= len(data) {
+			t.Fatalf("SFFO offset out of bounds: %d >= %d", v, len(data))
+		}
+		last = v
+	}
+}
+
+func firstMipPayload(data []byte) (payload []byte, w, h int, lzo bool, err error) {
+	taggs := parseTagg(data)
+	off := firstMipOffset(taggs)
+	if off == 0 || off+7 > len(data) {
+		return nil, 0, 0, false, ErrInsufficientData
+	}
+	rawW := binary.LittleEndian.Uint16(data[off : off+2])
+	rawH := binary.LittleEndian.Uint16(data[off+2 : off+4])
+	lzo = (rawW & 0x8000) != 0
+	w = int(rawW & 0x7FFF)
+	h = int(rawH)
+	size := int(data[off+4]) | int(data[off+5])<<8 | int(data[off+6])<<16
+	if off+7+size > len(data) {
+		return nil, 0, 0, false, ErrInsufficientData
+	}
+	return data[off+7 : off+7+size], w, h, lzo, nil
+}
+
+func ptrBool(v bool) *bool {
+	return &v
+}
+
+func TestDecodeMipAndForSize(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "test_*.paa"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testdata/test_*.paa files found: %v", err)
+	}
+
+	type onlyReader struct{ io.Reader }
+	for _, path := range files {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+
+			full, err := DecodePAA(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("DecodePAA: %v", err)
+			}
+
+			level := len(full.MipMaps) / 2
+			want, err := full.MipMaps[level].Image()
+			if err != nil {
+				t.Fatalf("Image: %v", err)
+			}
+
+			readers := map[string]func() io.Reader{
+				"seeker": func() io.Reader { return bytes.NewReader(raw) },
+				"stream": func() io.Reader { return onlyReader{Reader: bytes.NewReader(raw)} },
+			}
+			for name, newReader := range readers {
+				got, err := DecodeMip(newReader(), level)
+				if err != nil {
+					t.Fatalf("%s DecodeMip(%d): %v", name, level, err)
+				}
+				if !sameNRGBA(got, want) {
+					t.Fatalf("%s DecodeMip(%d) pixels differ", name, level)
+				}
+
+				maxDim := int(full.MipMaps[level].Width)
+				if h := int(full.MipMaps[level].Height); h > maxDim {
+					maxDim = h
+				}
+				got, err = DecodeMipForSize(newReader(), maxDim)
+				if err != nil {
+					t.Fatalf("%s DecodeMipForSize(%d): %v", name, maxDim, err)
+				}
+				if !sameNRGBA(got, want) {
+					t.Fatalf("%s DecodeMipForSize(%d) pixels differ", name, maxDim)
+				}
+
+				// Smaller than every mip: fall back to the smallest level.
+				last := full.MipMaps[len(full.MipMaps)-1]
+				got, err = DecodeMipForSize(newReader(), 1)
+				if err != nil {
+					t.Fatalf("%s DecodeMipForSize(1): %v", name, err)
+				}
+				if b := got.Bounds(); b.Dx() != int(last.Width) || b.Dy() != int(last.Height) {
+					t.Fatalf("%s DecodeMipForSize(1) size=%v, want %dx%d", name, b.Size(), last.Width, last.Height)
+				}
+
+				if _, err := DecodeMip(newReader(), len(full.MipMaps)); !errors.Is(err, ErrMipLevelOutOfRange) {
+					t.Fatalf("%s DecodeMip out of range err=%v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func sameNRGBA(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.NRGBAModel.Convert(a.At(x, y)) != color.NRGBAModel.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func TestValidateFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "test_*.paa"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testdata/test_*.paa files found: %v", err)
+	}
+
+	for _, path := range files {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read fixture: %v", err)
+		}
+
+		issues, err := ValidateFile(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s ValidateFile: %v", path, err)
+		}
+		if HasErrors(issues) {
+			t.Errorf("%s ValidateFile: %v", path, issues)
+		}
+
+		p, err := DecodePAA(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s DecodePAA: %v", path, err)
+		}
+		if issues := Validate(p); HasErrors(issues) {
+			t.Errorf("%s Validate: %v", path, issues)
+		}
+	}
+}
+
+func TestValidateBrokenFiles(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i) //nolint:gosec // G115
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, img, &EncodeOptions{Type: PaxARGB8}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	raw := buf.Bytes()
+
+	issues, err := ValidateFile(bytes.NewReader(raw))
+	if err != nil || len(issues) != 0 {
+		t.Fatalf("clean file: issues=%v err=%v", issues, err)
+	}
+
+	findKind := func(issues []Issue, kind IssueKind) *Issue {
+		for i := range issues {
+			if issues[i].Kind == kind {
+				return &issues[i]
+			}
+		}
+		return nil
+	}
+	hasKind := func(issues []Issue, kind IssueKind) bool {
+		return findKind(issues, kind) != nil
+	}
+
+	// Limits are errors, not truncation issues.
+	for name, opts := range map[string]*DecodeOptions{
+		"width": {MaxWidth: 8},
+		"total": {MaxTotalBytes: 1200},
+	} {
+		issues, err = ValidateFileWithOptions(bytes.NewReader(raw), opts)
+		if !errors.Is(err, ErrLimitExceeded) || issues != nil {
+			t.Errorf("%s limit: issues=%v err=%v, want ErrLimitExceeded", name, issues, err)
+		}
+	}
+
+	// Missing terminator.
+	issues, _ = ValidateFile(bytes.NewReader(raw[:len(raw)-6]))
+	if i := findKind(issues, IssueMissingTerminator); i == nil || i.Offset != int64(len(raw)-6) {
+		t.Errorf("truncated terminator: %v", issues)
+	}
+
+	// SFFO pointing past EOF.
+	broken := append([]byte(nil), raw...)
+	sffo := bytes.Index(broken, []byte("GGATSFFO")) + 12
+	binary.LittleEndian.PutUint32(broken[sffo+4:], uint32(len(raw)+100)) //nolint:gosec // G115
+	issues, _ = ValidateFile(bytes.NewReader(broken))
+	if !hasKind(issues, IssueSFFOPastEOF) || !hasKind(issues, IssueSFFOMismatch) {
+		t.Errorf("SFFO past EOF: %v", issues)
+	}
+	if i := findKind(issues, IssueSFFOPastEOF); i != nil && i.Offset != int64(sffo-12) {
+		t.Errorf("SFFO past EOF at offset %d, want the tag at %d", i.Offset, sffo-12)
+	}
+
+	p, err := DecodePAA(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	p.SetTag("CGVA", []byte{1, 2, 3})
+	p.MipMaps[1].Width = 7
+	issues = Validate(p)
+	for _, kind := range []IssueKind{IssueTagLength, IssueNonPowerOfTwo, IssueMipChain, IssueMipSize} {
+		if !hasKind(issues, kind) {
+			t.Errorf("Validate missing %v: %v", kind, issues)
+		}
+	}
+	// Validate has no file, so no offsets.
+	for _, i := range issues {
+		if i.Offset != -1 {
+			t.Errorf("Validate %v offset=%d, want -1", i.Kind, i.Offset)
+		}
+	}
+
+	p.MipMaps[1].Width = 8
+	p.SetTag("CGVA", []byte{0, 0, 0, 0})
+	issues = Validate(p)
+	if !hasKind(issues, IssueAverageColor) || HasErrors(issues) {
+		t.Errorf("CGVA mismatch: %v", issues)
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, genColorAlpha(), &EncodeOptions{Type: PaxARGB8}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	raw := buf.Bytes()
+
+	for name, opts := range map[string]*DecodeOptions{
+		"width":  {MaxWidth: 32},
+		"height": {MaxHeight: 32},
+		"mips":   {MaxMipCount: 2},
+		"total":  {MaxTotalBytes: 1024},
+		"tag":    {MaxTagSize: 2},
+	} {
+		if _, err := DecodePAAWithOptions(bytes.NewReader(raw), opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: DecodePAAWithOptions err=%v, want ErrLimitExceeded", name, err)
+		}
+		if _, err := DecodeMipWithOptions(bytes.NewReader(raw), 0, opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: DecodeMipWithOptions err=%v, want ErrLimitExceeded", name, err)
+		}
+	}
+
+	if _, err := DecodePAAWithOptions(bytes.NewReader(raw), &DecodeOptions{MaxWidth: 64, MaxMipCount: 16}); err != nil {
+		t.Fatalf("within limits: %v", err)
+	}
+
+	// The decoded image counts too: a solid DXT1 texture is small on disk and
+	// in DXT blocks, but its base level decodes to 256 KiB of NRGBA.
+	solid := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	draw.Draw(solid, solid.Bounds(), &image.Uniform{C: color.NRGBA{R: 90, G: 120, B: 30, A: 255}}, image.Point{}, draw.Src)
+	buf.Reset()
+	if err := EncodeWithOptions(&buf, solid, &EncodeOptions{Type: PaxDXT1}); err != nil {
+		t.Fatalf("EncodeWithOptions(solid): %v", err)
+	}
+	small := &DecodeOptions{MaxTotalBytes: 100 << 10}
+	if _, err := DecodePAAWithOptions(bytes.NewReader(buf.Bytes()), small); err != nil {
+		t.Fatalf("DecodePAAWithOptions(solid): %v", err)
+	}
+	if _, err := DecodeMipWithOptions(bytes.NewReader(buf.Bytes()), 2, small); err != nil {
+		t.Fatalf("DecodeMipWithOptions(solid, 2): %v", err)
+	}
+	for _, streaming := range []bool{false, true} {
+		opts := &DecodeOptions{MaxTotalBytes: small.MaxTotalBytes, Streaming: streaming}
+		if _, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("streaming=%v: DecodeWithOptions(solid) err=%v, want ErrLimitExceeded", streaming, err)
+		}
+	}
+
+	// A tag claiming 4 GiB must fail before allocating, even without options.
+	huge := []byte{0x88, 0x88, 'G', 'G', 'A', 'T', 'C', 'G', 'V', 'A', 0xff, 0xff, 0xff, 0xff}
+	if _, err := DecodePAA(bytes.NewReader(huge)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("huge tag err=%v, want ErrLimitExceeded", err)
+	}
+}
+
+func TestDecodeHostileMips(t *testing.T) {
+	// LZSS-compressed ARGB8 mip claiming 32768x32768 from 16 stored bytes.
+	var file bytes.Buffer
+	file.Write([]byte{0x88, 0x88, 0, 0})
+	_ = binary.Write(&file, binary.LittleEndian, uint16(32768))
+	_ = binary.Write(&file, binary.LittleEndian, uint16(32768))
+	file.Write([]byte{16, 0, 0})
+	file.Write(make([]byte, 16))
+	file.Write(make([]byte, 6))
+	d, err := NewStreamDecoder(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatalf("NewStreamDecoder: %v", err)
+	}
+	if _, err := d.Next(); !errors.Is(err, ErrInsufficientData) {
+		t.Fatalf("oversized LZSS claim err=%v, want ErrInsufficientData", err)
+	}
+
+	// 16-bit formats must not index past the image for short or long data.
+	for _, typ := range []PaxType{PaxARGBA5, PaxARGB4} {
+		long := &MipMap{Type: typ, Width: 2, Height: 2, Data: make([]byte, 64)}
+		if _, err := long.Image(); err != nil {
+			t.Errorf("%v long data: %v", typ, err)
+		}
+
+		short := &MipMap{Type: typ, Width: 4, Height: 4, Data: make([]byte, 6)}
+		if _, err := short.Image(); !errors.Is(err, ErrInsufficientData) {
+			t.Errorf("%v short data err=%v, want ErrInsufficientData", typ, err)
+		}
+	}
+}
+
+func TestDecodeErrorContext(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := EncodeWithOptions(&buf, img, &EncodeOptions{Type: PaxDXT5, UseLZO: true}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	raw := buf.Bytes()
+
+	m, err := DecodeMetadata(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("DecodeMetadata: %v", err)
+	}
+
+	// Corrupt the LZO payload of the second mip.
+	off := int64(m.MipHeaders[1].Offset)
+	bad := bytes.Clone(raw)
+	for i := off + 7; i < off+16; i++ {
+		bad[i] = 0xFF
+	}
+
+	for _, tc := range []struct {
+		decode func([]byte) error
+		name   string
+	}{
+		{name: "seek", decode: func(b []byte) error {
+			_, err := DecodeMip(bytes.NewReader(b), 1)
+			return err
+		}},
+		{name: "stream", decode: func(b []byte) error {
+			_, err := DecodeMipWithOptions(bytes.NewReader(b), 1, &DecodeOptions{Streaming: true})
+			return err
+		}},
+	} {
+		err := tc.decode(bad)
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Fatalf("%s: err=%v, want *DecodeError", tc.name, err)
+		}
+		if !errors.Is(err, ErrLZODecompress) {
+			t.Errorf("%s: errors.Is(ErrLZODecompress) false for %v", tc.name, err)
+		}
+		if de.Stage != StageLZO || de.Mip != 1 || de.Offset != off || de.Type != PaxDXT5 {
+			t.Errorf("%s: got stage=%v mip=%d offset=%d type=%v, want LZO mip 1 at %d (DXT5)",
+				tc.name, de.Stage, de.Mip, de.Offset, de.Type, off)
+		}
+	}
+
+	var de *DecodeError
+	_, err = DecodePAA(bytes.NewReader([]byte{0, 0, 0, 0}))
+	if !errors.As(err, &de) || de.Stage != StageHeader || !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("bad magic err=%v, want header stage wrapping ErrInvalidMagic", err)
+	}
+
+	// Tag header cut after the name of the first tag.
+	_, err = DecodePAA(bytes.NewReader(raw[:10]))
+	if !errors.As(err, &de) || de.Stage != StageTags || de.Offset != 2 {
+		t.Errorf("truncated tag err=%v, want tags stage at offset 2", err)
+	}
+}
+
+func TestImagesMatchDecodeMip(t *testing.T) {
+	for _, name := range []string{"test_nohq.paa", "test_smdi.paa", "test_mask.paa", "test_4444.paa"} {
+		raw, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("read fixture: %v", err)
+		}
+
+		p, err := DecodePAA(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s: DecodePAA: %v", name, err)
+		}
+
+		for _, workers := range []int{0, 1, 3, 16} {
+			opts := &DecodeOptions{BCn: &bcn.DecodeOptions{Workers: workers}}
+			imgs, err := p.Images(opts)
+			if err != nil {
+				t.Fatalf("%s: Images: %v", name, err)
+			}
+			if opts.BCn.Workers != workers {
+				t.Fatalf("%s: Images changed BCn.Workers to %d", name, opts.BCn.Workers)
+			}
+			if len(imgs) != len(p.MipMaps) {
+				t.Fatalf("%s: Images returned %d levels, want %d", name, len(imgs), len(p.MipMaps))
+			}
+
+			for i, img := range imgs {
+				want, err := DecodeMip(bytes.NewReader(raw), i)
+				if err != nil {
+					t.Fatalf("%s: DecodeMip(%d): %v", name, i, err)
+				}
+				if !sameNRGBA(img, want) {
+					t.Fatalf("%s: workers=%d level %d differs from DecodeMip", name, workers, i)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeMipChain(t *testing.T) {
+	colors := []color.NRGBA{{R: 200, A: 255}, {G: 200, A: 255}, {B: 200, A: 255}, {R: 50, G: 60, B: 70, A: 128}}
+	mips := make([]image.Image, 0, len(colors))
+	w, h := 16, 8
+	for _, c := range colors {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+		mips = append(mips, img)
+		w, h = max(w/2, 1), max(h/2, 1)
+	}
+
+	var buf bytes.Buffer
+	var reported []int
+	encoded := 0.0
+	opts := &EncodeOptions{Type: PaxARGB8, Progress: func(stage ProgressStage, mip int, f float64) {
+		if stage == ProgressEncode {
+			reported = append(reported, mip)
+			encoded = f
+		}
+	}}
+	if err := EncodeMipChain(&buf, mips, opts); err != nil {
+		t.Fatalf("EncodeMipChain: %v", err)
+	}
+	if len(reported) != len(mips) || encoded != 1 {
+		t.Errorf("EncodeMipChain progress reported mips %v ending at %.3f, want every level up to 1", reported, encoded)
+	}
+
+	p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	if len(p.MipMaps) != len(mips) {
+		t.Fatalf("decoded %d mips, want %d", len(p.MipMaps), len(mips))
+	}
+	for i, mm := range p.MipMaps {
+		img, err := mm.Image()
+		if err != nil {
+			t.Fatalf("mip %d: %v", i, err)
+		}
+		if !sameNRGBA(img, mips[i]) {
+			t.Errorf("mip %d differs from the supplied level", i)
+		}
+	}
+	if avg, _ := p.AverageColor(); avg != colors[0] {
+		t.Errorf("CGVA=%v, want level 0 color %v", avg, colors[0])
+	}
+
+	bad := []image.Image{mips[0], mips[2]}
+	if err := EncodeMipChain(io.Discard, bad, nil); !errors.Is(err, ErrInvalidMipChain) {
+		t.Errorf("skipped level err=%v, want ErrInvalidMipChain", err)
+	}
+	if err := EncodeMipChain(io.Discard, nil, nil); !errors.Is(err, ErrNoMipmaps) {
+		t.Errorf("empty chain err=%v, want ErrNoMipmaps", err)
+	}
+}
+
+func TestEncodePrecompressed(t *testing.T) {
+	opts := &EncodeOptions{Type: PaxDXT5, UseLZO: true, WriteGALF: true, GALFValue: byte(AlphaBinary)}
+	var src bytes.Buffer
+	if err := EncodeWithOptions(&src, genColorAlpha(), opts); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	want, err := DecodePAA(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodePrecompressed(&buf, want.MipMaps, opts); err != nil {
+		t.Fatalf("EncodePrecompressed: %v", err)
+	}
+	got, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+
+	if len(got.MipMaps) != len(want.MipMaps) {
+		t.Fatalf("got %d mips, want %d", len(got.MipMaps), len(want.MipMaps))
+	}
+	for i, mm := range got.MipMaps {
+		if !bytes.Equal(mm.Data, want.MipMaps[i].Data) || mm.Compressed != want.MipMaps[i].Compressed {
+			t.Errorf("mip %d blocks or LZO flag differ", i)
+		}
+	}
+	for _, name := range []string{"CXAM", "GALF"} {
+		if !bytes.Equal(got.Taggs[name], want.Taggs[name]) {
+			t.Errorf("%s=% x, want % x", name, got.Taggs[name], want.Taggs[name])
+		}
+	}
+	// CGVA comes from the decoded blocks, so it may drift slightly from the source average.
+	cgva, wantCGVA := got.Taggs["CGVA"], want.Taggs["CGVA"]
+	for i := range cgva {
+		if absDiffByte(cgva[i], wantCGVA[i]) > 4 {
+			t.Errorf("CGVA=% x, want close to % x", cgva, wantCGVA)
+			break
+		}
+	}
+
+	short := *want.MipMaps[1]
+	short.Data = short.Data[:len(short.Data)-1]
+	if err := EncodePrecompressed(io.Discard, []*MipMap{want.MipMaps[0], &short}, nil); !errors.Is(err, ErrInsufficientData) {
+		t.Errorf("short blocks err=%v, want ErrInsufficientData", err)
+	}
+	if err := EncodePrecompressed(io.Discard, []*MipMap{want.MipMaps[0], want.MipMaps[2]}, nil); !errors.Is(err, ErrInvalidMipChain) {
+		t.Errorf("skipped level err=%v, want ErrInvalidMipChain", err)
+	}
+	argb := &MipMap{Type: PaxARGB8, Width: 1, Height: 1, Data: make([]byte, 4)}
+	if err := EncodePrecompressed(io.Discard, []*MipMap{argb}, nil); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("ARGB8 err=%v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestPowerOfTwoPolicy(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 48, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 48; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 5), G: uint8(y * 12), B: 90, A: 255})
+		}
+	}
+
+	if err := EncodeWithOptions(io.Discard, src, &EncodeOptions{PowerOfTwo: PowerOfTwoReject}); !errors.Is(err, ErrNonPowerOfTwo) {
+		t.Errorf("reject err=%v, want ErrNonPowerOfTwo", err)
+	}
+
+	cases := []struct {
+		policy PowerOfTwoPolicy
+		w, h   int
+	}{
+		{PowerOfTwoAllow, 48, 20},
+		{PowerOfTwoScaleNearest, 64, 16},
+		{PowerOfTwoScaleUp, 64, 32},
+		{PowerOfTwoScaleDown, 32, 16},
+		{PowerOfTwoPad, 64, 32},
+	}
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		opts := &EncodeOptions{Type: PaxARGB8, PowerOfTwo: tc.policy, ResampleFilter: ResampleTriangle}
+		if err := EncodeWithOptions(&buf, src, opts); err != nil {
+			t.Fatalf("%v: %v", tc.policy, err)
+		}
+		p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v: DecodePAA: %v", tc.policy, err)
+		}
+		if w, h := int(p.MipMaps[0].Width), int(p.MipMaps[0].Height); w != tc.w || h != tc.h {
+			t.Errorf("%v: base %dx%d, want %dx%d", tc.policy, w, h, tc.w, tc.h)
+		}
+
+		if tc.policy != PowerOfTwoPad {
+			continue
+		}
+		img, err := p.MipMaps[0].Image()
+		if err != nil {
+			t.Fatalf("pad: %v", err)
+		}
+		nrgba := toNRGBA(img)
+		if got, want := nrgba.NRGBAAt(63, 31), src.NRGBAAt(47, 19); got != want {
+			t.Errorf("pad corner=%v, want edge pixel %v", got, want)
+		}
+		if got, want := nrgba.NRGBAAt(10, 5), src.NRGBAAt(10, 5); got != want {
+			t.Errorf("pad interior=%v, want %v", got, want)
+		}
+	}
+
+	mips := []image.Image{src, image.NewNRGBA(image.Rect(0, 0, 24, 10))}
+	if err := EncodeMipChain(io.Discard, mips, &EncodeOptions{PowerOfTwo: PowerOfTwoPad}); !errors.Is(err, ErrNonPowerOfTwo) {
+		t.Errorf("mip chain err=%v, want ErrNonPowerOfTwo", err)
+	}
+
+	cfg, err := texconfig.DefaultTexConvertConfig()
+	if err != nil {
+		t.Fatalf("default texconfig: %v", err)
+	}
+	for _, name := range []string{"tex_co.png", "tex.png"} {
+		if err := EncodeWithTexConfig(io.Discard, src, name, cfg); !errors.Is(err, ErrNonPowerOfTwo) {
+			t.Errorf("%s: err=%v, want ErrNonPowerOfTwo", name, err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := EncodeWithTexConfigOptions(&buf, src, "tex_co.png", cfg, &EncodeOptions{PowerOfTwo: PowerOfTwoScaleDown}); err != nil {
+		t.Fatalf("texconfig scale down: %v", err)
+	}
+	cfgOut, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeConfig: %v", err)
+	}
+	if cfgOut.Width != 32 || cfgOut.Height != 16 {
+		t.Errorf("texconfig scale down: %dx%d, want 32x16", cfgOut.Width, cfgOut.Height)
+	}
+}
+
+func TestResampleAlphaWeighted(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 6, 6))
+	// Opaque red on the left, fully transparent black on the right.
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 3; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	for _, f := range []ResampleFilter{ResampleNearest, ResampleBox, ResampleTriangle, ResampleCatmullRom, ResampleLanczos, ResampleKaiser, ResampleMitchell} {
+		dst := resampleWith(src, 8, 4, resampleParams{filter: f})
+		if b := dst.Bounds(); b.Dx() != 8 || b.Dy() != 4 {
+			t.Fatalf("%v: size %v", f, b)
+		}
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 8; x++ {
+				c := dst.NRGBAAt(x, y)
+				if c.A != 0 && (c.R != 255 || c.G != 0 || c.B != 0) {
+					t.Fatalf("%v: pixel (%d,%d)=%v, transparent black bled into color", f, x, y, c)
+				}
+			}
+		}
+		if c := dst.NRGBAAt(0, 0); c.A != 255 {
+			t.Errorf("%v: left edge alpha=%d, want 255", f, c.A)
+		}
+	}
+}
+
+func TestMipKernels(t *testing.T) {
+	// A tileable checker of 2-pixel stripes: every kernel must average it to
+	// flat grey one level down when wrapping, with no darker or lighter seam.
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			v := uint8(0)
+			if (x/2+y/2)%2 == 0 {
+				v = 200
+			}
+			src.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+
+	for _, k := range []ResampleFilter{ResampleBox, ResampleTriangle, ResampleKaiser, ResampleLanczos, ResampleMitchell} {
+		mips, _ := generateMipmapsWithFilter(src, mipGenOptions{kernel: k, wrap: true}, nil)
+		if len(mips) != 5 {
+			t.Fatalf("%v: %d levels, want 5", k, len(mips))
+		}
+
+		last := toNRGBA(mips[len(mips)-1]).NRGBAAt(0, 0)
+		if absDiffByte(last.R, 100) > 2 || last.A != 255 {
+			t.Errorf("%v: 1x1 level=%v, want grey 100", k, last)
+		}
+
+		m2 := toNRGBA(mips[2])
+		ref := m2.NRGBAAt(1, 1).R
+		for _, p := range []image.Point{{0, 0}, {3, 0}, {0, 3}, {3, 3}} {
+			if c := m2.NRGBAAt(p.X, p.Y).R; absDiffByte(c, ref) > 2 {
+				t.Errorf("%v: level 2 edge %v=%d, interior %d (seam)", k, p, c, ref)
+			}
+		}
+	}
+
+	// Gamma-correct box of black and white is brighter than the plain average.
+	bw := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	bw.SetNRGBA(0, 0, color.NRGBA{A: 255})
+	bw.SetNRGBA(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	plainMips, _ := generateMipmapsWithFilter(bw, mipGenOptions{kernel: ResampleBox}, nil)
+	linearMips, _ := generateMipmapsWithFilter(bw, mipGenOptions{kernel: ResampleBox, useSRGB: true}, nil)
+	plain := toNRGBA(plainMips[1]).NRGBAAt(0, 0)
+	linear := toNRGBA(linearMips[1]).NRGBAAt(0, 0)
+	if absDiffByte(plain.R, 128) > 1 || absDiffByte(linear.R, 188) > 1 {
+		t.Errorf("box R=%d sRGB box R=%d, want 128 and 188", plain.R, linear.R)
+	}
+
+	var buf bytes.Buffer
+	opts := &EncodeOptions{Type: PaxARGB8, MipKernel: ResampleKaiser, WrapEdges: true}
+	if err := EncodeWithOptions(&buf, src, opts); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	if len(p.MipMaps) != 5 {
+		t.Errorf("encoded %d mips, want 5", len(p.MipMaps))
+	}
+}
+
+func TestDitherGradient(t *testing.T) {
+	const w, h = 256, 16
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(x), B: uint8(255 - x), A: uint8(x)})
+		}
+	}
+
+	// columnError is the mean distance between each column's average R and the source.
+	columnError := func(typ PaxType, mode DitherMode) float64 {
+		var buf bytes.Buffer
+		no := false
+		opts := &EncodeOptions{Type: typ, Dither: mode, GenerateMipmaps: &no}
+		if err := EncodeWithOptions(&buf, src, opts); err != nil {
+			t.Fatalf("%v %v: %v", typ, mode, err)
+		}
+		img, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v %v: Decode: %v", typ, mode, err)
+		}
+
+		nrgba := toNRGBA(img)
+		total := 0.0
+		for x := 0; x < w; x++ {
+			sum := 0
+			for y := 0; y < h; y++ {
+				sum += int(nrgba.NRGBAAt(x, y).R)
+			}
+			total += math.Abs(float64(sum)/h - float64(x))
+		}
+
+		return total / w
+	}
+
+	for _, typ := range []PaxType{PaxARGB4, PaxARGBA5} {
+		plain := columnError(typ, DitherNone)
+		for _, mode := range []DitherMode{DitherOrdered, DitherFloydSteinberg} {
+			if got := columnError(typ, mode); got > plain*0.6 {
+				t.Errorf("%v %v: column error %.2f, truncation %.2f", typ, mode, got, plain)
+			}
+		}
+	}
+
+	cfg, err := texconfig.DefaultTexConvertConfig()
+	if err != nil {
+		t.Fatalf("default texconfig: %v", err)
+	}
+	on := true
+	hint := texconfig.TextureHint{Format: texconfig.TexFormatARGB4444, Dithering: &on}
+	opts, err := EncodeOptionsFromHint(src, hint, cfg, false)
+	if err != nil {
+		t.Fatalf("EncodeOptionsFromHint: %v", err)
+	}
+	if opts.Dither != DitherFloydSteinberg {
+		t.Errorf("hint Dither=%v, want %v", opts.Dither, DitherFloydSteinberg)
+	}
+	applyEncodeOverrides(opts, &EncodeOptions{Dither: DitherNone, ForceCXAMFull: opts.ForceCXAMFull})
+	if opts.Dither != DitherNone {
+		t.Errorf("override Dither=%v, want %v", opts.Dither, DitherNone)
+	}
+}
+
+func TestEncodeDecodeContext(t *testing.T) {
+	// Odd sizes leave partial blocks and a short last strip.
+	const w, h = 70, 602
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 3), G: uint8(y), B: uint8(x ^ y), A: uint8(255 - y/3)})
+		}
+	}
+
+	var want bytes.Buffer
+	if err := EncodeWithOptions(&want, src, &EncodeOptions{Type: PaxDXT5}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+
+	// Strip-wise encoding must match whole-level encoding byte for byte.
+	var got bytes.Buffer
+	last := map[ProgressStage]float64{}
+	opts := &EncodeOptions{Type: PaxDXT5, Progress: func(stage ProgressStage, _ int, f float64) {
+		if f < last[stage] {
+			t.Errorf("%v progress went back from %.3f to %.3f", stage, last[stage], f)
+		}
+		last[stage] = f
+	}}
+	if err := EncodeContext(context.Background(), &got, src, opts); err != nil {
+		t.Fatalf("EncodeContext: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatal("EncodeContext output differs from EncodeWithOptions")
+	}
+	for _, stage := range []ProgressStage{ProgressMipmaps, ProgressEncode} {
+		if last[stage] != 1 {
+			t.Errorf("%v progress ended at %.3f, want 1", stage, last[stage])
+		}
+	}
+
+	// Cancelling from the callback stops at the next strip and writes nothing.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got.Reset()
+	opts.Progress = func(stage ProgressStage, _ int, _ float64) {
+		if stage == ProgressEncode {
+			cancel()
+		}
+	}
+	if err := EncodeContext(ctx, &got, src, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled EncodeContext err=%v, want context.Canceled", err)
+	}
+	if got.Len() != 0 {
+		t.Errorf("cancelled EncodeContext wrote %d bytes", got.Len())
+	}
+
+	// A kernel chain reports each level and stops between them.
+	var fractions []float64
+	opts = &EncodeOptions{Type: PaxDXT5, MipKernel: ResampleLanczos, Progress: func(stage ProgressStage, _ int, f float64) {
+		if stage == ProgressMipmaps && f > 0 {
+			fractions = append(fractions, f)
+		}
+	}}
+	if err := EncodeContext(context.Background(), io.Discard, src, opts); err != nil {
+		t.Fatalf("EncodeContext with kernel: %v", err)
+	}
+	if len(fractions) < 3 || fractions[0] >= 1 {
+		t.Errorf("kernel mip progress %v, want per-level fractions", fractions)
+	}
+	mipCtx, mipCancel := context.WithCancel(context.Background())
+	defer mipCancel()
+	got.Reset()
+	encoded := false
+	opts.Progress = func(stage ProgressStage, _ int, f float64) {
+		if stage == ProgressMipmaps && f > 0 && f < 1 {
+			mipCancel()
+		}
+		if stage == ProgressEncode {
+			encoded = true
+		}
+	}
+	if err := EncodeContext(mipCtx, &got, src, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("EncodeContext cancelled in mip generation err=%v, want context.Canceled", err)
+	}
+	if encoded || got.Len() != 0 {
+		t.Errorf("EncodeContext cancelled in mip generation went on to encode (wrote %d bytes)", got.Len())
+	}
+
+	plain, err := Decode(bytes.NewReader(want.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	decoded := 0.0
+	img, err := DecodeContext(context.Background(), bytes.NewReader(want.Bytes()), &DecodeOptions{
+		Progress: func(stage ProgressStage, mip int, f float64) {
+			if stage == ProgressDecode && mip == 0 {
+				decoded = f
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("DecodeContext: %v", err)
+	}
+	if !sameNRGBA(img, plain) {
+		t.Error("DecodeContext image differs from Decode")
+	}
+	if decoded != 1 {
+		t.Errorf("decode progress ended at %.3f, want 1", decoded)
+	}
+
+	read := 0.0
+	p, err := DecodePAAContext(context.Background(), bytes.NewReader(want.Bytes()), &DecodeOptions{
+		Progress: func(_ ProgressStage, _ int, f float64) { read = f },
+	})
+	if err != nil {
+		t.Fatalf("DecodePAAContext: %v", err)
+	}
+	if len(p.MipMaps) < 2 || read != 1 {
+		t.Errorf("DecodePAAContext read %d mips, progress %.3f", len(p.MipMaps), read)
+	}
+
+	done, stop := context.WithCancel(context.Background())
+	stop()
+	if _, err := DecodeContext(done, bytes.NewReader(want.Bytes()), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled DecodeContext err=%v, want context.Canceled", err)
+	}
+	if _, err := DecodePAAContext(done, bytes.NewReader(want.Bytes()), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled DecodePAAContext err=%v, want context.Canceled", err)
+	}
+}
+
+func TestEncodeWorkersIdentical(t *testing.T) {
+	const w, h = 256, 256
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y * 7), B: uint8(x * y >> 4), A: uint8(255 - x/2)})
+		}
+	}
+
+	for _, typ := range []PaxType{PaxDXT1, PaxDXT5, PaxARGB4, PaxP8} {
+		// LZSS is slow; a smaller base still yields several levels.
+		img := image.Image(src)
+		if !isDXT(typ) {
+			img = src.SubImage(image.Rect(0, 0, 128, 64))
+		}
+
+		var want []byte
+		for _, workers := range []int{1, 0, 3, 64} {
+			var buf bytes.Buffer
+			opts := &EncodeOptions{
+				Type:   typ,
+				UseLZO: true,
+				Dither: DitherFloydSteinberg,
+				BCn:    &bcn.EncodeOptions{Workers: workers},
+			}
+			if err := EncodeWithOptions(&buf, img, opts); err != nil {
+				t.Fatalf("%v workers=%d: %v", typ, workers, err)
+			}
+
+			if want == nil {
+				want = buf.Bytes()
+				continue
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%v: workers=%d output differs from serial encoding", typ, workers)
+			}
+		}
+	}
+}
+
+// opaqueImage hides the concrete type of an image, forcing the generic At paths.
+type opaqueImage struct{ image.Image }
+
+func TestPixFastPathsMatchAt(t *testing.T) {
+	const w, h = 40, 24
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint8(255)
+			if y >= h/2 {
+				a = uint8(x * 6)
+			}
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 6), G: uint8(y * 10), B: uint8(x * y), A: a})
+		}
+	}
+
+	rgba := image.NewRGBA(src.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), src, image.Point{}, draw.Src)
+	gray := image.NewGray(src.Bounds())
+	draw.Draw(gray, gray.Bounds(), src, image.Point{}, draw.Src)
+	pal := image.NewPaletted(src.Bounds(), color.Palette{color.Black, color.NRGBA{R: 200, G: 90, B: 30, A: 128}, color.White})
+	draw.Draw(pal, pal.Bounds(), src, image.Point{}, draw.Src)
+	// A palette longer than a uint8 index can reach must not overflow the lookup.
+	long := image.NewPaletted(src.Bounds(), make(color.Palette, 300))
+	for i := range long.Palette {
+		long.Palette[i] = color.NRGBA{R: uint8(i), G: uint8(i * 3), B: uint8(i >> 1), A: uint8(255 - i/2)}
+	}
+	for i := range long.Pix {
+		long.Pix[i] = uint8(i * 11)
+	}
+	shifted := image.NewNRGBA(image.Rect(-5, -3, w+2, h+4))
+	draw.Draw(shifted, src.Bounds(), src, image.Point{}, draw.Src)
+	ycc := image.NewYCbCr(image.Rect(-3, 1, w-3, h+1), image.YCbCrSubsampleRatio420)
+	for i := range ycc.Y {
+		ycc.Y[i] = uint8(i * 7)
+	}
+	for i := range ycc.Cb {
+		ycc.Cb[i], ycc.Cr[i] = uint8(i*13), uint8(255-i*5)
+	}
+	variants := map[string]image.Image{
+		"nrgba":   src,
+		"rgba":    rgba,
+		"gray":    gray,
+		"paleted": pal,
+		"pal300":  long,
+		"subimg":  shifted.SubImage(src.Bounds()),
+		"ycbcr":   ycc,
+	}
+
+	no := false
+	for name, img := range variants {
+		if !sameNRGBA(toNRGBA(img), toNRGBA(opaqueImage{img})) {
+			t.Errorf("%s: toNRGBA differs from the At conversion", name)
+		}
+		if got, want := analyzeImage(img), analyzeImage(opaqueImage{img}); got != want {
+			t.Errorf("%s: analysis %+v, At path %+v", name, got, want)
+		}
+
+		for _, typ := range []PaxType{PaxDXT5, PaxDXT2, PaxARGB8, PaxARGB4, PaxARGBA5, PaxGRAYA} {
+			for _, nm := range []bool{false, true} {
+				if typ == PaxDXT5 && !nm {
+					// The image goes to bcn as is, which reads non-NRGBA images premultiplied.
+					continue
+				}
+
+				opts := &EncodeOptions{Type: typ, NormalMapSwizzle: nm, GenerateMipmaps: &no}
+				var got, want bytes.Buffer
+				if err := EncodeWithOptions(&got, img, opts); err != nil {
+					t.Fatalf("%s %v: %v", name, typ, err)
+				}
+				if err := EncodeWithOptions(&want, opaqueImage{img}, opts); err != nil {
+					t.Fatalf("%s %v: %v", name, typ, err)
+				}
+				if !bytes.Equal(got.Bytes(), want.Bytes()) {
+					t.Errorf("%s %v normal=%v: output differs from the At path", name, typ, nm)
+				}
+			}
+		}
+	}
 }
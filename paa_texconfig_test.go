@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"image"
 	"image/color"
+	"io"
 	"math"
 	"math/rand"
 	"testing"
@@ -101,11 +102,303 @@ func TestTexConfigHeadersFromPNG(t *testing.T) {
 	}
 }
 
+func TestParseZIWSTagRoundTrip(t *testing.T) {
+	cases := map[[4]byte]string{
+		{0x05, 0x04, 0x02, 0x03}: "R=1-A G=G B=B A=1-R",
+		{0x02, 0x09, 0x03, 0x09}: "R=0 G=B B=0 A=G",
+		{0x08, 0x01, 0x02, 0x03}: "R=R G=G B=B A=1",
+		{0x06, 0x01, 0x04, 0x03}: "R=R G=1-A B=B A=1-G",
+	}
+	for tag, want := range cases {
+		swz, err := texconfig.ParseZIWSTag(tag)
+		if err != nil {
+			t.Fatalf("ParseZIWSTag(% x): %v", tag, err)
+		}
+		if got := swz.String(); got != want {
+			t.Errorf("ParseZIWSTag(% x)=%q, want %q", tag, got, want)
+		}
+
+		back, ok, err := swz.ZIWSTag()
+		if err != nil || !ok || back != tag {
+			t.Errorf("ZIWSTag of %q = % x ok=%v err=%v, want % x", want, back, ok, err, tag)
+		}
+	}
+
+	if _, err := texconfig.ParseZIWSTag([4]byte{0x0A, 0x01, 0x02, 0x03}); err == nil {
+		t.Errorf("ParseZIWSTag accepted selector 0x0a")
+	}
+}
+
+func TestInverseSwizzleRoundTrip(t *testing.T) {
+	orig := genColorAlpha().(*image.NRGBA)
+	noMips := false
+	tags := map[string][4]byte{
+		"smdi":  {0x08, 0x08, 0x02, 0x03},
+		"as":    {0x08, 0x08, 0x02, 0x08},
+		"nopx":  {0x05, 0x00, 0x02, 0x03},
+		"sky":   {0x06, 0x01, 0x04, 0x03},
+		"adshq": {0x02, 0x09, 0x03, 0x09},
+	}
+	for name, tag := range tags {
+		swz, err := texconfig.ParseZIWSTag(tag)
+		if err != nil {
+			t.Fatalf("%s: ParseZIWSTag: %v", name, err)
+		}
+
+		var buf bytes.Buffer
+		opts := &EncodeOptions{Type: PaxARGB8, Swizzle: &swz, WriteSwizzleTag: true, SwizzleTag: tag, GenerateMipmaps: &noMips}
+		if err := EncodeWithOptions(&buf, orig, opts); err != nil {
+			t.Fatalf("%s: EncodeWithOptions: %v", name, err)
+		}
+
+		// Original channels that some stored channel still holds.
+		var kept [4]bool
+		for _, e := range []texconfig.SwizzleExpr{swz.R, swz.G, swz.B, swz.A} {
+			if !e.IsConst {
+				kept[nrgbaIndex(e.Source)] = true
+			}
+		}
+
+		img, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", name, err)
+		}
+		got := toNRGBA(img)
+		for i := 0; i < len(orig.Pix); i += 4 {
+			for c := range kept {
+				if kept[c] && got.Pix[i+c] != orig.Pix[i+c] {
+					t.Fatalf("%s: pixel %d channel %d = %d, want %d", name, i/4, c, got.Pix[i+c], orig.Pix[i+c])
+				}
+			}
+		}
+
+		raw, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{RawChannels: true})
+		if err != nil {
+			t.Fatalf("%s: DecodeWithOptions(RawChannels): %v", name, err)
+		}
+		if !sameNRGBA(raw, texconfig.ApplyChannelSwizzle(orig, swz)) {
+			t.Errorf("%s: RawChannels differs from the stored swizzle", name)
+		}
+	}
+}
+
+func TestInverseSwizzleMipChainDXT(t *testing.T) {
+	// A two-color checker of 16 px cells stays two-colored down to the 4x4
+	// level, so every DXT block only has to hold its two endpoints.
+	opaque := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	translucent := image.NewNRGBA(opaque.Bounds())
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{R: 200, G: 40, B: 90, A: 230}
+			if (x/16+y/16)%2 == 1 {
+				c = color.NRGBA{R: 30, G: 180, B: 250, A: 70}
+			}
+			translucent.SetNRGBA(x, y, c)
+			c.A = 255
+			opaque.SetNRGBA(x, y, c)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		src  image.Image
+		typ  PaxType
+		tag  [4]byte
+	}{
+		// DXT1 alpha is 1 bit, so the stored alpha is the constant one.
+		{name: "dxt1_swap_rb", src: opaque, typ: PaxDXT1, tag: [4]byte{0x08, 0x03, 0x02, 0x01}},
+		{name: "dxt5_nopx", src: translucent, typ: PaxDXT5, tag: [4]byte{0x05, 0x00, 0x02, 0x03}},
+		{name: "dxt5_sky", src: translucent, typ: PaxDXT5, tag: [4]byte{0x06, 0x01, 0x04, 0x03}},
+	} {
+		swz, err := texconfig.ParseZIWSTag(tc.tag)
+		if err != nil {
+			t.Fatalf("%s: ParseZIWSTag: %v", tc.name, err)
+		}
+
+		// Uncompressed, unswizzled mips of the same chain are the reference;
+		// it goes down to 1x1 while DXT stops at 4x4.
+		var refBuf, buf bytes.Buffer
+		if err := EncodeWithOptions(&refBuf, tc.src, &EncodeOptions{Type: PaxARGB8}); err != nil {
+			t.Fatalf("%s: reference encode: %v", tc.name, err)
+		}
+		opts := &EncodeOptions{Type: tc.typ, Swizzle: &swz, WriteSwizzleTag: true, SwizzleTag: tc.tag}
+		if err := EncodeWithOptions(&buf, tc.src, opts); err != nil {
+			t.Fatalf("%s: EncodeWithOptions: %v", tc.name, err)
+		}
+
+		ref, err := DecodePAA(bytes.NewReader(refBuf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: DecodePAA(reference): %v", tc.name, err)
+		}
+		refImages, err := ref.Images(nil)
+		if err != nil {
+			t.Fatalf("%s: reference Images: %v", tc.name, err)
+		}
+		p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: DecodePAA: %v", tc.name, err)
+		}
+		if len(p.MipMaps) < 4 || len(p.MipMaps) > len(refImages) {
+			t.Fatalf("%s: %d mips, reference %d", tc.name, len(p.MipMaps), len(refImages))
+		}
+		images, err := p.Images(nil)
+		if err != nil {
+			t.Fatalf("%s: Images: %v", tc.name, err)
+		}
+
+		var kept [4]bool
+		for _, e := range []texconfig.SwizzleExpr{swz.R, swz.G, swz.B, swz.A} {
+			if !e.IsConst {
+				kept[nrgbaIndex(e.Source)] = true
+			}
+		}
+
+		for level, mm := range p.MipMaps {
+			img, err := mm.Image()
+			if err != nil {
+				t.Fatalf("%s mip %d: Image: %v", tc.name, level, err)
+			}
+			if !sameNRGBA(img, images[level]) {
+				t.Fatalf("%s mip %d: MipMap.Image differs from PAA.Images", tc.name, level)
+			}
+			single, err := DecodeMip(bytes.NewReader(buf.Bytes()), level)
+			if err != nil {
+				t.Fatalf("%s mip %d: DecodeMip: %v", tc.name, level, err)
+			}
+			if !sameNRGBA(single, img) {
+				t.Fatalf("%s mip %d: DecodeMip differs from MipMap.Image", tc.name, level)
+			}
+
+			// Each restored channel stays within DXT error of the reference,
+			// far below the 100+ a channel mixed up with another would differ by.
+			got, want := toNRGBA(img), toNRGBA(refImages[level])
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("%s mip %d: %v, reference %v", tc.name, level, got.Bounds(), want.Bounds())
+			}
+			for c := range kept {
+				if !kept[c] {
+					continue
+				}
+				sum := 0
+				for i := c; i < len(want.Pix); i += 4 {
+					d := int(got.Pix[i]) - int(want.Pix[i])
+					sum += max(d, -d)
+				}
+				if mean := sum / (len(want.Pix) / 4); mean > 16 {
+					t.Errorf("%s mip %d channel %d: mean error %d", tc.name, level, c, mean)
+				}
+			}
+		}
+	}
+}
+
+func TestMipImageFollowsTagChanges(t *testing.T) {
+	orig := genColorAlpha().(*image.NRGBA)
+	tag := [4]byte{0x05, 0x00, 0x02, 0x03}
+	swz, err := texconfig.ParseZIWSTag(tag)
+	if err != nil {
+		t.Fatalf("ParseZIWSTag: %v", err)
+	}
+
+	noMips := false
+	var buf bytes.Buffer
+	opts := &EncodeOptions{Type: PaxARGB8, Swizzle: &swz, WriteSwizzleTag: true, SwizzleTag: tag, GenerateMipmaps: &noMips}
+	if err := EncodeWithOptions(&buf, orig, opts); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+
+	p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+	stored := texconfig.ApplyChannelSwizzle(orig, swz)
+
+	p.DeleteTag("ZIWS")
+	img, err := p.MipMaps[0].Image()
+	if err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	if !sameNRGBA(img, stored) {
+		t.Errorf("Image still inverts the deleted ZIWS tag")
+	}
+
+	p.SetTag("ZIWS", tag[:])
+	img, err = p.MipMaps[0].Image()
+	if err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	if got := toNRGBA(img).NRGBAAt(5, 9); got != orig.NRGBAAt(5, 9) {
+		t.Errorf("Image after SetTag(ZIWS) = %v, want %v", got, orig.NRGBAAt(5, 9))
+	}
+
+	p.SetMaxColor(color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	full, err := p.MipMaps[0].ImageWithOptions(&DecodeOptions{ApplyMaxColor: true})
+	if err != nil {
+		t.Fatalf("ImageWithOptions: %v", err)
+	}
+	p.SetMaxColor(color.NRGBA{R: 128, G: 255, B: 255, A: 255})
+	half, err := p.MipMaps[0].ImageWithOptions(&DecodeOptions{ApplyMaxColor: true})
+	if err != nil {
+		t.Fatalf("ImageWithOptions: %v", err)
+	}
+	if a, b := toNRGBA(full).NRGBAAt(40, 40).R, toNRGBA(half).NRGBAAt(40, 40).R; b >= a {
+		t.Errorf("ApplyMaxColor ignores SetMaxColor: red %d with 255, %d with 128", a, b)
+	}
+}
+
 type testCase struct {
 	name string
 	gen  func() image.Image
 }
 
+func TestAlphaCoveragePreserved(t *testing.T) {
+	// Thin soft-edged blades: the box chain blurs them below the alpha test.
+	src := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			// Blades 13 px apart whose width changes along y.
+			d := math.Abs(float64(x%13)-4.2) / (0.5 + float64(y%37)/24)
+			a := uint8(math.Max(0, 255-d*110))
+			src.SetNRGBA(x, y, color.NRGBA{G: 160, A: a})
+		}
+	}
+
+	const ref = 128
+	want := alphaCoverage(src, ref)
+	plain, _ := generateMipmapsWithFilter(src, mipGenOptions{}, nil)
+	kept, _ := generateMipmapsWithFilter(src, mipGenOptions{coverageRef: ref}, nil)
+	if got := alphaCoverage(toNRGBA(plain[3]), ref); got > want*0.7 {
+		t.Fatalf("fixture keeps coverage without the option (%.3f vs %.3f)", got, want)
+	}
+	for level := 1; level <= 3; level++ {
+		if got := alphaCoverage(toNRGBA(kept[level]), ref); math.Abs(got-want) > 0.05 {
+			t.Errorf("level %d coverage %.3f, want %.3f", level, got, want)
+		}
+	}
+
+	cfg, err := texconfig.ParseTexConvertConfig(`class TextureHints {
+		class Foliage { name = "*_cat.*"; format = "DXT5"; alphaCoverageRef = 128; };
+	};`)
+	if err != nil {
+		t.Fatalf("ParseTexConvertConfig: %v", err)
+	}
+	hint, ok := texconfig.Resolve("grass_cat.png", cfg)
+	if !ok || hint.AlphaCoverageRef != ref {
+		t.Fatalf("hint=%+v ok=%v, want AlphaCoverageRef %d", hint, ok, ref)
+	}
+	opts, err := EncodeOptionsFromHint(src, hint, cfg, false)
+	if err != nil {
+		t.Fatalf("EncodeOptionsFromHint: %v", err)
+	}
+	if opts.AlphaCoverageRef != ref {
+		t.Errorf("AlphaCoverageRef=%d, want %d", opts.AlphaCoverageRef, ref)
+	}
+
+	if _, err := texconfig.ParseTexConvertConfig(`class TextureHints { class Bad { alphaCoverageRef = 300; }; };`); err == nil {
+		t.Error("alphaCoverageRef=300 parsed without error")
+	}
+}
+
 func makeTestCases() []testCase {
 	return []testCase{
 		{name: "test_co.paa", gen: genColor},
@@ -459,3 +752,147 @@ func clamp(v, lo, hi int) int {
 	}
 	return v
 }
+
+func TestExpandDynRange(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 6), G: uint8(y * 3), B: 200, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	opts := &EncodeOptions{Type: PaxARGB8, ExpandDynRange: true, ForceCXAMFull: true}
+	if err := EncodeWithOptions(&buf, src, opts); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+	p, err := DecodePAA(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodePAA: %v", err)
+	}
+
+	if mx, _ := p.MaxColor(); mx != (color.NRGBA{R: 90, G: 45, B: 200, A: 255}) {
+		t.Errorf("CXAM=%v, want the source maxima", mx)
+	}
+	stored, err := p.MipMaps[0].Image()
+	if err != nil {
+		t.Fatalf("Image: %v", err)
+	}
+	if c := toNRGBA(stored).NRGBAAt(15, 15); c.R != 255 || c.G != 255 || c.B != 255 {
+		t.Errorf("stored max pixel=%v, want expanded to 255", c)
+	}
+	if avg, _ := p.AverageColor(); [4]uint8{avg.R, avg.G, avg.B, avg.A} != colorStatsOf(stored).avg {
+		t.Errorf("CGVA=%v, want the stored average", avg)
+	}
+
+	restored, err := p.MipMaps[0].ImageWithOptions(&DecodeOptions{ApplyMaxColor: true})
+	if err != nil {
+		t.Fatalf("ImageWithOptions: %v", err)
+	}
+	rn := toNRGBA(restored)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			got, want := rn.NRGBAAt(x, y), src.NRGBAAt(x, y)
+			if absDiffByte(got.R, want.R) > 1 || absDiffByte(got.G, want.G) > 1 || got.B != want.B {
+				t.Fatalf("(%d,%d) restored %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	cfg, err := texconfig.DefaultTexConvertConfig()
+	if err != nil {
+		t.Fatalf("default texconfig: %v", err)
+	}
+	if cfg.ExpandDynRange || !cfg.UseSRGBFromDynRange {
+		t.Fatalf("default config extension flags: %+v", cfg)
+	}
+	hint, _ := texconfig.Resolve("dark_co.png", cfg)
+	hopts, err := EncodeOptionsFromHint(src, hint, cfg, false)
+	if err != nil {
+		t.Fatalf("EncodeOptionsFromHint: %v", err)
+	}
+	if hopts.ExpandDynRange {
+		t.Error("default config enabled ExpandDynRange")
+	}
+
+	cfg.ExpandDynRange = true
+	hopts, err = EncodeOptionsFromHint(src, hint, cfg, false)
+	if err != nil {
+		t.Fatalf("EncodeOptionsFromHint: %v", err)
+	}
+	if !hopts.ExpandDynRange {
+		t.Error("dynRange hint did not enable ExpandDynRange")
+	}
+}
+
+func TestDefaultConfigColorMapRoundTrip(t *testing.T) {
+	cfg, err := texconfig.DefaultTexConvertConfig()
+	if err != nil {
+		t.Fatalf("default texconfig: %v", err)
+	}
+
+	want := color.NRGBA{R: 100, G: 60, B: 40, A: 255}
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i+0], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = want.R, want.G, want.B, want.A
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWithTexConfig(&buf, src, "wall_co.png", cfg); err != nil {
+		t.Fatalf("EncodeWithTexConfig: %v", err)
+	}
+	img, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	// DXT1 stores 5:6:5 colors.
+	got := toNRGBA(img).NRGBAAt(7, 7)
+	if absDiffByte(got.R, want.R) > 4 || absDiffByte(got.G, want.G) > 4 || absDiffByte(got.B, want.B) > 4 || got.A != 255 {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+}
+
+func TestEnableDXTFalseFallback(t *testing.T) {
+	cfg, err := texconfig.DefaultTexConvertConfig()
+	if err != nil {
+		t.Fatalf("default texconfig: %v", err)
+	}
+
+	fill := func(c func(x, y int) color.NRGBA) image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				img.SetNRGBA(x, y, c(x, y))
+			}
+		}
+
+		return img
+	}
+	off := false
+	cases := []struct {
+		img  image.Image
+		want PaxType
+	}{
+		{fill(func(x, _ int) color.NRGBA {
+			return color.NRGBA{R: uint8(x * 30), G: uint8(x * 30), B: uint8(x * 30), A: 255}
+		}), PaxGRAYA},
+		{fill(func(x, _ int) color.NRGBA { return color.NRGBA{R: uint8(x * 30), G: 10, B: 20, A: 255} }), PaxARGBA5},
+		{fill(func(x, _ int) color.NRGBA { return color.NRGBA{R: 10, G: uint8(x * 30), B: 20, A: uint8(x * 30)} }), PaxARGB4},
+	}
+	for _, format := range []texconfig.TexFormat{texconfig.TexFormatDefault, texconfig.TexFormatDXT5} {
+		hint := texconfig.TextureHint{Format: format, EnableDXT: &off}
+		for _, tc := range cases {
+			opts, err := EncodeOptionsFromHint(tc.img, hint, cfg, false)
+			if err != nil {
+				t.Fatalf("%v: %v", format, err)
+			}
+			if opts.Type != tc.want {
+				t.Errorf("%v: type %v, want %v", format, opts.Type, tc.want)
+			}
+			if err := EncodeWithOptions(io.Discard, tc.img, opts); err != nil {
+				t.Errorf("%v %v: encode: %v", format, opts.Type, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package paa
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// paletteColors converts raw palette triplets (BGR) into a color.Palette.
+func paletteColors(raw []byte) color.Palette {
+	if len(raw) < 3 {
+		return nil
+	}
+
+	pal := make(color.Palette, 0, len(raw)/3)
+	for i := 0; i+3 <= len(raw); i += 3 {
+		pal = append(pal, color.NRGBA{R: raw[i+2], G: raw[i+1], B: raw[i+0], A: 255})
+	}
+
+	return pal
+}
+
+// paletteBytes converts a color.Palette into raw palette triplets (BGR).
+func paletteBytes(pal color.Palette) []byte {
+	raw := make([]byte, 0, len(pal)*3)
+	for _, c := range pal {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		raw = append(raw, n.B, n.G, n.R)
+	}
+
+	return raw
+}
+
+// grayPalette is used for P8 mips decoded without a file palette.
+func grayPalette() color.Palette {
+	pal := make(color.Palette, maxPaletteColors)
+	for i := range pal {
+		pal[i] = color.NRGBA{R: uint8(i), G: uint8(i), B: uint8(i), A: 255} //nolint:gosec // G115: i < 256
+	}
+
+	return pal
+}
+
+// indexedPalette returns the palette P8 mips are decoded with: the gray ramp
+// without a file palette, otherwise pal padded with opaque black to 256 entries
+// so that every index is addressable.
+func indexedPalette(pal color.Palette) color.Palette {
+	if len(pal) == 0 {
+		return grayPalette()
+	}
+	if len(pal) >= maxPaletteColors {
+		return pal
+	}
+
+	padded := make(color.Palette, maxPaletteColors)
+	copy(padded, pal)
+	for i := len(pal); i < len(padded); i++ {
+		padded[i] = color.NRGBA{A: 255}
+	}
+
+	return padded
+}
+
+// decodePaletted wraps 8-bit palette indices into an image.Paletted with the
+// palette from indexedPalette.
+func decodePaletted(data []byte, width, height int, pal color.Palette) (*image.Paletted, error) {
+	total := width * height
+	if len(data) < total {
+		return nil, ErrInsufficientData
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, width, height), indexedPalette(pal))
+	copy(img.Pix, data[:total])
+
+	return img, nil
+}
+
+// encodePaletted maps img to the nearest palette entries and returns 8-bit indices.
+func encodePaletted(img image.Image, pal color.Palette) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return nil, ErrInvalidDimensions
+	}
+	if len(pal) == 0 || len(pal) > maxPaletteColors {
+		return nil, ErrUnsupportedPixelFmt
+	}
+
+	raw := make([]byte, 0, w*h)
+	cache := make(map[color.NRGBA]uint8, 256)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			c.A = 255
+
+			idx, ok := cache[c]
+			if !ok {
+				idx = uint8(pal.Index(c)) //nolint:gosec // G115: palette has at most 256 entries
+				cache[c] = idx
+			}
+			raw = append(raw, idx)
+		}
+	}
+
+	return raw, nil
+}
+
+// histEntry is one distinct RGB color with its pixel count.
+type histEntry struct {
+	count   int
+	r, g, b uint8
+}
+
+// quantizePalette builds a palette of up to maxColors entries using median cut.
+// Alpha is ignored because P8 textures carry no alpha channel.
+func quantizePalette(img image.Image, maxColors int) color.Palette {
+	b := img.Bounds()
+	counts := make(map[uint32]int)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			counts[uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B)]++
+		}
+	}
+
+	entries := make([]histEntry, 0, len(counts))
+	for key, n := range counts {
+		entries = append(entries, histEntry{
+			r:     uint8(key >> 16), //nolint:gosec // G115: masked byte
+			g:     uint8(key >> 8),  //nolint:gosec // G115: masked byte
+			b:     uint8(key),       //nolint:gosec // G115: masked byte
+			count: n,
+		})
+	}
+
+	// Stable input order keeps the palette deterministic.
+	sort.Slice(entries, func(i, j int) bool {
+		return histKey(entries[i]) < histKey(entries[j])
+	})
+
+	boxes := [][]histEntry{entries}
+	for len(boxes) < maxColors {
+		// Split the box with the widest channel range.
+		best, bestRange, bestCh := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			ch, rng := widestChannel(box)
+			if rng > bestRange {
+				best, bestRange, bestCh = i, rng, ch
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.SliceStable(box, func(i, j int) bool {
+			return histChannel(box[i], bestCh) < histChannel(box[j], bestCh)
+		})
+
+		total := 0
+		for _, e := range box {
+			total += e.count
+		}
+
+		// Split at the weighted median, keeping both halves non-empty.
+		acc, split := 0, 1
+		for i, e := range box[:len(box)-1] {
+			acc += e.count
+			split = i + 1
+			if acc*2 >= total {
+				break
+			}
+		}
+
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, bl, n int
+		for _, e := range box {
+			r += int(e.r) * e.count
+			g += int(e.g) * e.count
+			bl += int(e.b) * e.count
+			n += e.count
+		}
+		if n == 0 {
+			continue
+		}
+
+		pal = append(pal, color.NRGBA{
+			R: uint8((r + n/2) / n),  //nolint:gosec // G115: average of bytes
+			G: uint8((g + n/2) / n),  //nolint:gosec // G115: average of bytes
+			B: uint8((bl + n/2) / n), //nolint:gosec // G115: average of bytes
+			A: 255,
+		})
+	}
+
+	return pal
+}
+
+// histKey packs an entry color into a sortable key.
+func histKey(e histEntry) uint32 {
+	return uint32(e.r)<<16 | uint32(e.g)<<8 | uint32(e.b)
+}
+
+// histChannel returns the channel value (0=R, 1=G, 2=B) of an entry.
+func histChannel(e histEntry, ch int) uint8 {
+	switch ch {
+	case 0:
+		return e.r
+	case 1:
+		return e.g
+	default:
+		return e.b
+	}
+}
+
+// widestChannel returns the channel with the largest value range in the box.
+func widestChannel(box []histEntry) (int, int) {
+	bestCh, bestRange := 0, 0
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, e := range box {
+			v := int(histChannel(e, ch))
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > bestRange {
+			bestCh, bestRange = ch, hi-lo
+		}
+	}
+
+	return bestCh, bestRange
+}
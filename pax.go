@@ -1,5 +1,7 @@
 package paa
 
+import "fmt"
+
 // PaxType defines the pixel format in a PAA file.
 type PaxType uint32
 
@@ -14,11 +16,18 @@ const (
 	PaxARGBA5 PaxType = 3  // 0x1555 ARGBA5
 	PaxARGB8  PaxType = 5  // 0x8888 ARGB8
 	PaxGRAYA  PaxType = 1  // 0x8080 GRAYA
+
+	// PaxP8 is an indexed 8-bit palette texture (legacy PAC). It has no file magic.
+	// BI's enum value for P8 is 0, which EncodeOptions reserves for auto selection.
+	PaxP8 PaxType = 11
 )
 
 // Bytes returns the 2-byte file magic for this format.
+// PaxP8 has no magic and returns an empty slice.
 func (p PaxType) Bytes() []byte {
 	switch p {
+	case PaxP8:
+		return []byte{}
 	case PaxDXT1:
 		return []byte{1, 255}
 	case PaxDXT2:
@@ -42,6 +51,34 @@ func (p PaxType) Bytes() []byte {
 	}
 }
 
+// String returns the format name (e.g. "DXT5", "ARGB1555", "P8").
+func (p PaxType) String() string {
+	switch p {
+	case PaxDXT1:
+		return "DXT1"
+	case PaxDXT2:
+		return "DXT2"
+	case PaxDXT3:
+		return "DXT3"
+	case PaxDXT4:
+		return "DXT4"
+	case PaxDXT5:
+		return "DXT5"
+	case PaxARGB4:
+		return "ARGB4444"
+	case PaxARGBA5:
+		return "ARGB1555"
+	case PaxARGB8:
+		return "ARGB8888"
+	case PaxGRAYA:
+		return "AI88"
+	case PaxP8:
+		return "P8"
+	default:
+		return fmt.Sprintf("PaxType(%d)", uint32(p))
+	}
+}
+
 // PaxTypeFromBytes parses the 2-byte magic into a PaxType.
 func PaxTypeFromBytes(b []byte) (PaxType, bool) {
 	if len(b) < 2 {
@@ -77,3 +114,18 @@ func PaxTypeFromBytes(b []byte) (PaxType, bool) {
 func isDXT(t PaxType) bool {
 	return t == PaxDXT1 || t == PaxDXT2 || t == PaxDXT3 || t == PaxDXT4 || t == PaxDXT5
 }
+
+// isKnownPaxType reports whether the PaxType can be written to a file.
+func isKnownPaxType(t PaxType) bool {
+	if t == PaxP8 {
+		return true
+	}
+
+	_, ok := PaxTypeFromBytes(t.Bytes())
+	return ok
+}
+
+// isPremultipliedDXT returns true for DXT formats that store premultiplied alpha (DXT2, DXT4).
+func isPremultipliedDXT(t PaxType) bool {
+	return t == PaxDXT2 || t == PaxDXT4
+}
@@ -0,0 +1,140 @@
+package paa
+
+import (
+	"fmt"
+	"image"
+)
+
+// PowerOfTwoPolicy selects how encoding handles images whose width or height
+// is not a power of two. The engine only loads power-of-two textures.
+type PowerOfTwoPolicy int
+
+const (
+	// PowerOfTwoDefault is PowerOfTwoAllow for EncodeWithOptions and
+	// PowerOfTwoReject for EncodeWithTexConfig.
+	PowerOfTwoDefault PowerOfTwoPolicy = iota
+	// PowerOfTwoAllow encodes any size as is.
+	PowerOfTwoAllow
+	// PowerOfTwoReject fails with ErrNonPowerOfTwo.
+	PowerOfTwoReject
+	// PowerOfTwoScaleNearest scales each dimension to the nearest power of two
+	// (ties scale up) using EncodeOptions.ResampleFilter.
+	PowerOfTwoScaleNearest
+	// PowerOfTwoScaleUp scales each dimension up to the next power of two.
+	PowerOfTwoScaleUp
+	// PowerOfTwoScaleDown scales each dimension down to the previous power of two.
+	PowerOfTwoScaleDown
+	// PowerOfTwoPad extends the canvas right and down to the next power of two,
+	// repeating the edge pixels so that mips do not bleed in a border.
+	PowerOfTwoPad
+)
+
+// String returns the policy name.
+func (p PowerOfTwoPolicy) String() string {
+	switch p {
+	case PowerOfTwoDefault:
+		return "default"
+	case PowerOfTwoAllow:
+		return "allow"
+	case PowerOfTwoReject:
+		return "reject"
+	case PowerOfTwoScaleNearest:
+		return "scale-nearest"
+	case PowerOfTwoScaleUp:
+		return "scale-up"
+	case PowerOfTwoScaleDown:
+		return "scale-down"
+	case PowerOfTwoPad:
+		return "pad"
+	default:
+		return fmt.Sprintf("PowerOfTwoPolicy(%d)", int(p))
+	}
+}
+
+// applyPowerOfTwo returns img resized or padded to power-of-two dimensions
+// according to policy, or img itself when no change is needed or allowed.
+func applyPowerOfTwo(img image.Image, policy PowerOfTwoPolicy, rp resampleParams) (image.Image, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if isPowerOfTwo(w) && isPowerOfTwo(h) {
+		return img, nil
+	}
+
+	switch policy {
+	case PowerOfTwoDefault, PowerOfTwoAllow:
+		return img, nil
+	case PowerOfTwoScaleNearest:
+		return resampleWith(img, nearestPowerOfTwo(w), nearestPowerOfTwo(h), rp), nil
+	case PowerOfTwoScaleUp:
+		return resampleWith(img, nextPowerOfTwo(w), nextPowerOfTwo(h), rp), nil
+	case PowerOfTwoScaleDown:
+		return resampleWith(img, prevPowerOfTwo(w), prevPowerOfTwo(h), rp), nil
+	case PowerOfTwoPad:
+		return padImage(img, nextPowerOfTwo(w), nextPowerOfTwo(h)), nil
+	default:
+		return nil, fmt.Errorf("%w: %dx%d", ErrNonPowerOfTwo, w, h)
+	}
+}
+
+// checkPowerOfTwo rejects a non-POT size unless policy allows it; supplied
+// levels cannot be resized, so scale and pad policies reject too.
+func checkPowerOfTwo(size image.Point, policy PowerOfTwoPolicy) error {
+	if policy == PowerOfTwoDefault || policy == PowerOfTwoAllow {
+		return nil
+	}
+	if !isPowerOfTwo(size.X) || !isPowerOfTwo(size.Y) {
+		return fmt.Errorf("%w: %dx%d", ErrNonPowerOfTwo, size.X, size.Y)
+	}
+
+	return nil
+}
+
+// nextPowerOfTwo returns the smallest power of two >= n (1 for n <= 1).
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+
+	return p
+}
+
+// prevPowerOfTwo returns the largest power of two <= n (1 for n <= 1).
+func prevPowerOfTwo(n int) int {
+	p := nextPowerOfTwo(n)
+	if p > n && p > 1 {
+		p >>= 1
+	}
+
+	return p
+}
+
+// nearestPowerOfTwo returns the power of two closest to n; ties round up.
+func nearestPowerOfTwo(n int) int {
+	up, down := nextPowerOfTwo(n), prevPowerOfTwo(n)
+	if n-down < up-n {
+		return down
+	}
+
+	return up
+}
+
+// padImage copies img into a w x h canvas, repeating the last column and row.
+func padImage(img image.Image, w, h int) *image.NRGBA {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		so := src.PixOffset(b.Min.X, b.Min.Y+min(y, b.Dy()-1))
+		row := src.Pix[so : so+b.Dx()*4]
+		do := dst.PixOffset(0, y)
+		copy(dst.Pix[do:], row)
+
+		last := row[len(row)-4:]
+		for x := b.Dx(); x < w; x++ {
+			copy(dst.Pix[do+x*4:], last)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,194 @@
+package paa
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/woozymasta/bcn"
+)
+
+// ProgressStage names the step reported to a ProgressFunc.
+type ProgressStage int
+
+const (
+	// ProgressMipmaps is building the mip chain from the base image.
+	ProgressMipmaps ProgressStage = iota + 1
+	// ProgressEncode is converting mips to the pixel format (BCn compression,
+	// palette or 16-bit packing) and compressing them with LZO/LZSS.
+	ProgressEncode
+	// ProgressRead is reading and decompressing stored mip blocks.
+	ProgressRead
+	// ProgressDecode is converting mip data into pixels (BCn decoding).
+	ProgressDecode
+)
+
+// String returns a short name of the stage.
+func (s ProgressStage) String() string {
+	switch s {
+	case ProgressMipmaps:
+		return "mipmaps"
+	case ProgressEncode:
+		return "encode"
+	case ProgressRead:
+		return "read"
+	case ProgressDecode:
+		return "decode"
+	default:
+		return fmt.Sprintf("ProgressStage(%d)", int(s))
+	}
+}
+
+// ProgressFunc receives progress reports from EncodeContext and DecodeContext.
+// mip is the level being worked on (0 is the largest) and fraction is the
+// completed share of the whole stage, from 0 to 1, weighted by pixel count.
+// Each stage ends with a report of 1. Calls are never concurrent, but mips
+// encoded in parallel may report from different goroutines and out of level
+// order. The callback should return quickly.
+type ProgressFunc func(stage ProgressStage, mip int, fraction float64)
+
+// bcnStripRows is the height of the strips DXT levels are encoded and decoded
+// in when work can be cancelled or reported; a multiple of the 4x4 block size.
+const bcnStripRows = 256
+
+// workState carries the context and progress callback of one encode or decode.
+// A nil *workState never cancels and reports nothing.
+type workState struct {
+	ctx      context.Context
+	progress ProgressFunc
+}
+
+// newWorkState returns the state for ctx and progress, nil when neither can
+// have any effect.
+func newWorkState(ctx context.Context, progress ProgressFunc) *workState {
+	if ctx.Done() == nil && progress == nil {
+		return nil
+	}
+
+	return &workState{ctx: ctx, progress: progress}
+}
+
+// err returns the context error once the work has been cancelled.
+func (s *workState) err() error {
+	if s == nil {
+		return nil
+	}
+
+	return s.ctx.Err()
+}
+
+// report forwards one progress report to the callback, if any.
+func (s *workState) report(stage ProgressStage, mip int, fraction float64) {
+	if s == nil || s.progress == nil {
+		return
+	}
+
+	s.progress(stage, mip, min(fraction, 1))
+}
+
+// stageProgress accumulates the pixels done in one stage of known size.
+// It is safe for concurrent use by the levels of one encode.
+type stageProgress struct {
+	work  *workState
+	mu    sync.Mutex
+	stage ProgressStage
+	done  int
+	total int
+}
+
+// add accounts for n more pixels of mip and reports the new fraction.
+func (p *stageProgress) add(mip, n int) {
+	if p.work == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.work.report(p.stage, mip, float64(p.done)/float64(max(p.total, 1)))
+}
+
+// mipChainFraction estimates the share of a full mip chain done once the level
+// with cur pixels is read, base being the pixels of level 0. The smaller levels
+// hold a third of the pixels of the level above them, so cur/3 of 4*base/3 is left.
+func mipChainFraction(base, cur int) float64 {
+	return 1 - float64(cur)/float64(4*max(base, 1))
+}
+
+// mipPixels returns the pixel count of mm.
+func mipPixels(mm *MipMap) int {
+	return int(mm.Width) * int(mm.Height)
+}
+
+// encodeBCn compresses img to the BCn blocks of paxType. With work set, it
+// encodes strips of bcnStripRows rows so that cancellation and progress are
+// observed between them; blocks are stored row by row, so the result is identical.
+func encodeBCn(img image.Image, paxType PaxType, opts *bcn.EncodeOptions, prog *stageProgress, mip int) ([]byte, error) {
+	if err := prog.work.err(); err != nil {
+		return nil, err
+	}
+
+	format := paxToBcnFormat(paxType)
+	b := img.Bounds()
+	if prog.work == nil || b.Dy() <= bcnStripRows {
+		data, _, _, err := bcn.EncodeImageWithOptions(img, format, opts)
+		prog.add(mip, b.Dx()*b.Dy())
+		return data, err
+	}
+
+	src := toNRGBA(img)
+	b = src.Bounds()
+	out := make([]byte, 0, expectedMipSize(paxType, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y += bcnStripRows {
+		if err := prog.work.err(); err != nil {
+			return nil, err
+		}
+
+		strip := image.Rect(b.Min.X, y, b.Max.X, min(y+bcnStripRows, b.Max.Y))
+		data, _, _, err := bcn.EncodeImageWithOptions(src.SubImage(strip), format, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, data...)
+		prog.add(mip, strip.Dx()*strip.Dy())
+	}
+
+	return out, nil
+}
+
+// decodeBCn decodes w x h BCn blocks of paxType. With work set, it decodes
+// strips of bcnStripRows rows and observes cancellation and progress between them.
+func decodeBCn(data []byte, w, h int, paxType PaxType, opts *bcn.DecodeOptions, work *workState, mip int) (*image.NRGBA, error) {
+	// Short data is left to bcn, which reports it for the whole level.
+	format := paxToBcnFormat(paxType)
+	if work == nil || h <= bcnStripRows || len(data) < expectedMipSize(paxType, w, h) {
+		img, err := bcn.DecodeImageWithOptions(data, w, h, format, opts)
+		if err == nil {
+			work.report(ProgressDecode, mip, 1)
+		}
+
+		return img, err
+	}
+
+	rowBytes := expectedMipSize(paxType, w, 4)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y += bcnStripRows {
+		if err := work.err(); err != nil {
+			return nil, err
+		}
+
+		sh := min(bcnStripRows, h-y)
+		off := y / 4 * rowBytes
+		strip, err := bcn.DecodeImageWithOptions(data[off:off+(sh+3)/4*rowBytes], w, sh, format, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		copy(dst.Pix[dst.PixOffset(0, y):], strip.Pix)
+		work.report(ProgressDecode, mip, float64(y+sh)/float64(h))
+	}
+
+	return dst, nil
+}
@@ -1,8 +1,9 @@
 package paa
 
 import (
+	"context"
+	"errors"
 	"image"
-	"image/color"
 	"io"
 )
 
@@ -14,115 +15,213 @@ func Decode(r io.Reader) (image.Image, error) {
 
 // DecodeWithOptions reads a PAA stream and returns the first mip level as an image
 // using optional BCn decode settings.
+//
+// Readers that do not implement io.Seeker (e.g. pipes, network streams, or the
+// bufio.Reader used by image.Decode) are decoded forward-only: only the header
+// and the first mip block are read. DecodeOptions.Streaming forces this mode.
 func DecodeWithOptions(r io.Reader, opts *DecodeOptions) (image.Image, error) {
-	p, err := DecodePAA(r)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(p.MipMaps) == 0 {
-		return nil, ErrNoMipmaps
-	}
-
-	img, err := p.MipMaps[0].ImageWithOptions(opts)
-	if err != nil {
-		return nil, err
-	}
+	return DecodeMipWithOptions(r, 0, opts)
+}
 
-	return applySwizzleTag(p, img), nil
+// DecodeContext is DecodeWithOptions with cancellation and progress reports.
+//
+// ctx is checked before the first mip is read and between strips of its DXT
+// blocks; once it is done, decoding stops and returns ctx.Err(). Progress goes
+// to opts.Progress (see ProgressFunc).
+func DecodeContext(ctx context.Context, r io.Reader, opts *DecodeOptions) (image.Image, error) {
+	return decodeSelectedMip(r, opts, newWorkState(ctx, decodeProgress(opts)), func(i int, _, _ uint16) bool {
+		return i == 0
+	}, false)
 }
 
-// DecodeConfig reads only the dimensions of the first mip level.
+// DecodeConfig reads only the header and the dimensions of the first mip level,
+// without reading or decompressing any mip payload.
 // It implements the signature required by image.RegisterFormat.
+//
+// ColorModel is the model of the image Decode returns: the file palette (padded
+// to 256 entries) for P8 and color.NRGBAModel for the other formats, GRAYA
+// included.
 func DecodeConfig(r io.Reader) (image.Config, error) {
-	p, err := DecodePAA(r)
+	h, mr, err := readHeader(r, newDecodeLimits(nil))
 	if err != nil {
 		return image.Config{}, err
 	}
 
-	if len(p.MipMaps) == 0 {
+	// The first mip block always follows the header, so SFFO is not needed.
+	w, hgt, err := readMipDimensions(mr, h.typ)
+	if err == io.EOF || (err == nil && w == 0 && hgt == 0) {
 		return image.Config{}, ErrNoMipmaps
 	}
+	if err != nil {
+		return image.Config{}, mipError(err, h.typ, 0, h.mipStart)
+	}
 
-	mm := p.MipMaps[0]
 	return image.Config{
-		ColorModel: color.NRGBAModel,
-		Width:      int(mm.Width),
-		Height:     int(mm.Height),
+		ColorModel: colorModelFor(h.typ, h.palette),
+		Width:      int(w),
+		Height:     int(hgt),
 	}, nil
 }
 
-// applySwizzleTag applies the ZIWS tag to the image if it exists and the texture is a DXT5 normal map.
-func applySwizzleTag(p *PAA, img image.Image) image.Image {
-	tag, ok := p.Taggs["ZIWS"]
-	if !ok || len(tag) != 4 || p.Type != PaxDXT5 {
-		return img
-	}
-
-	var swiz [4]byte
-	copy(swiz[:], tag)
-	if swiz == swizzleDXT5NM {
-		return unswizzleNormalMap(img)
-	}
-
-	if swiz == [4]byte{0x02, 0x09, 0x03, 0x09} {
-		return applyADSHQSwizzle(img)
-	}
-
-	return applySwizzlePayload(img, swiz)
-}
-
 // DecodePAA reads a full PAA structure from the stream.
 //
-// File layout: 2-byte magic (PaxType), then GGAT tags (name + size + payload).
+// File layout: 2-byte magic (PaxType, absent for indexed P8 files), then GGAT
+// tags (name + size + payload) and the palette (triplet count + triplets).
 // The SFFO tag holds a table of absolute file offsets to each mip level; we seek
-// to each offset and read the mip (width, height, length, payload). If r does
-// not implement io.Seeker, the entire stream is read into memory first so that
-// we can seek (e.g. for image.Decode which may pass a non-seekable reader).
+// to each offset and read the mip (width, height, length, payload). When SFFO is
+// missing, all zeros, or points at unreadable data, the mip chain is walked
+// sequentially from the end of the header instead and PAA.DerivedOffsets is
+// set. If r does not implement io.Seeker, the entire stream is read into memory
+// first so that we can seek; use DecodePAAStream to read non-seekable streams
+// forward-only.
 func DecodePAA(r io.Reader) (*PAA, error) {
-	var err error
-	r, seeker, err := ensureSeeker(r)
-	if err != nil {
+	return DecodePAAWithOptions(r, nil)
+}
+
+// DecodePAAWithOptions is DecodePAA with resource limits from opts
+// (see DecodeOptions); exceeding one fails with ErrLimitExceeded.
+func DecodePAAWithOptions(r io.Reader, opts *DecodeOptions) (*PAA, error) {
+	return DecodePAAContext(context.Background(), r, opts)
+}
+
+// DecodePAAContext is DecodePAAWithOptions with cancellation and progress
+// reports. ctx is checked between mips; once it is done, decoding stops and
+// returns ctx.Err(). Progress goes to opts.Progress as ProgressRead reports.
+func DecodePAAContext(ctx context.Context, r io.Reader, opts *DecodeOptions) (*PAA, error) {
+	lim := newDecodeLimits(opts)
+	work := newWorkState(ctx, decodeProgress(opts))
+	if err := work.err(); err != nil {
 		return nil, err
 	}
 
-	pType, err := readPaxType(r)
+	r, seeker, err := ensureSeeker(r, lim)
 	if err != nil {
 		return nil, err
 	}
 
-	tags, err := readGGATTags(r)
+	h, _, err := readHeader(r, lim)
 	if err != nil {
 		return nil, err
 	}
 
-	offsets, err := sffoOffsets(tags)
+	taggs := h.tags.Map()
+	paa := &PAA{
+		Type:    h.typ,
+		Taggs:   taggs,
+		Tags:    h.tags,
+		Palette: h.palette,
+	}
+
+	mark := *lim
+	offsets, err := sffoOffsets(taggs)
 	if err != nil {
+		err = h.sffoError(err)
+	} else {
+		paa.MipMaps, err = readMipMapsAt(r, seeker, paa.Type, offsets, lim, work)
+	}
+	if werr := work.err(); werr != nil {
+		return nil, werr
+	}
+	if errors.Is(err, ErrLimitExceeded) {
 		return nil, err
 	}
 
-	paa := &PAA{
-		Type:  pType,
-		Taggs: tags,
+	// Missing or unusable SFFO: derive offsets by walking the mip chain.
+	if err != nil {
+		if _, serr := seeker.Seek(h.mipStart, io.SeekStart); serr != nil {
+			return nil, err
+		}
+
+		*lim = mark
+		mips, werr := walkMipMaps(r, paa.Type, h.mipStart, lim, work)
+		if cerr := work.err(); cerr != nil {
+			return nil, cerr
+		}
+		if errors.Is(werr, ErrLimitExceeded) {
+			return nil, werr
+		}
+		if werr != nil {
+			return nil, err
+		}
+
+		paa.MipMaps = mips
+		paa.DerivedOffsets = true
+	}
+	if len(paa.MipMaps) == 0 {
+		// Keep the SFFO error of a fallback that found nothing either.
+		return nil, errors.Join(ErrNoMipmaps, err)
+	}
+
+	for _, mm := range paa.MipMaps {
+		mm.file = paa
 	}
+	work.report(ProgressRead, max(len(paa.MipMaps)-1, 0), 1)
 
-	// Read mipmaps from SFFO offsets.
+	return paa, nil
+}
+
+// decodeProgress returns opts.Progress, nil for nil opts.
+func decodeProgress(opts *DecodeOptions) ProgressFunc {
+	if opts == nil {
+		return nil
+	}
+
+	return opts.Progress
+}
+
+// readMipMapsAt reads mipmaps at the given absolute file offsets.
+// work, when not nil, is checked before each mip and gets ProgressRead reports.
+func readMipMapsAt(r io.Reader, seeker io.Seeker, paxType PaxType, offsets []uint32, lim *decodeLimits, work *workState) ([]*MipMap, error) {
+	mips := make([]*MipMap, 0, len(offsets))
 	for _, offset := range offsets {
-		if _, err := seeker.Seek(int64(offset), io.SeekStart); err != nil {
+		if err := work.err(); err != nil {
 			return nil, err
 		}
+		if _, err := seeker.Seek(int64(offset), io.SeekStart); err != nil {
+			return nil, mipError(err, paxType, len(mips), int64(offset))
+		}
 
-		mm, err := readMipMap(r, paa.Type)
+		mm, err := readMipMap(r, paxType, lim)
 		if err != nil {
-			return nil, err
+			return nil, mipError(err, paxType, len(mips), int64(offset))
 		}
 
 		if mm == nil {
 			continue
 		}
 
-		paa.MipMaps = append(paa.MipMaps, mm)
+		mips = append(mips, mm)
+		work.report(ProgressRead, len(mips)-1, mipChainFraction(mipPixels(mips[0]), mipPixels(mm)))
 	}
 
-	return paa, nil
+	return mips, nil
+}
+
+// walkMipMaps reads mip blocks sequentially from the current position, which
+// is file offset start, until the zero terminator (or a clean end of stream).
+// work is observed as in readMipMapsAt.
+func walkMipMaps(r io.Reader, paxType PaxType, start int64, lim *decodeLimits, work *workState) ([]*MipMap, error) {
+	cr := &countingReader{r: r, n: start}
+	mips := make([]*MipMap, 0, 16)
+	for {
+		if err := work.err(); err != nil {
+			return nil, err
+		}
+
+		offset := cr.n
+		mm, err := readMipMap(cr, paxType, lim)
+		if err == io.EOF && cr.n == offset {
+			return mips, nil
+		}
+		if err != nil {
+			return nil, mipError(err, paxType, len(mips), offset)
+		}
+
+		if mm == nil {
+			return mips, nil
+		}
+
+		mips = append(mips, mm)
+		work.report(ProgressRead, len(mips)-1, mipChainFraction(mipPixels(mips[0]), mipPixels(mm)))
+	}
 }
@@ -8,13 +8,13 @@ import (
 )
 
 // ensureSeeker wraps non-seekable readers into bytes.Reader.
-func ensureSeeker(r io.Reader) (io.Reader, io.Seeker, error) {
+func ensureSeeker(r io.Reader, lim *decodeLimits) (io.Reader, io.Seeker, error) {
 	seeker, ok := r.(io.Seeker)
 	if ok {
 		return r, seeker, nil
 	}
 
-	data, err := io.ReadAll(r)
+	data, err := lim.readAll(r)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -23,53 +23,199 @@ func ensureSeeker(r io.Reader) (io.Reader, io.Seeker, error) {
 	return br, br, nil
 }
 
-// readPaxType reads and validates first 2 bytes as pax type.
-func readPaxType(r io.Reader) (PaxType, error) {
-	var header [2]byte
-	if _, err := io.ReadFull(r, header[:]); err != nil {
-		return 0, err
+// fileHeader is the part of a PAA file that precedes the first mip block.
+type fileHeader struct {
+	// tags holds GGAT entries in file order.
+	tags TagList
+	// palette holds raw palette triplets (empty for non-indexed formats).
+	palette []byte
+	// mipStart is the file offset of the first mip block.
+	mipStart int64
+	// typ is the pax type (PaxP8 when the magic is absent).
+	typ PaxType
+}
+
+// readHeader parses pax type, GGAT tags and palette.
+//
+// Indexed (P8) files carry no pax magic: they start either with GGAT tags or,
+// for legacy PAC files without tags, directly with the palette triplet count.
+// The returned reader is positioned at the first mip block.
+func readHeader(r io.Reader, lim *decodeLimits) (*fileHeader, io.Reader, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, nil, decodeError(StageHeader, 0, 0, err)
 	}
 
-	pType, ok := PaxTypeFromBytes(header[:])
-	if !ok {
-		return 0, ErrInvalidMagic
+	h := &fileHeader{}
+	if pType, ok := PaxTypeFromBytes(magic[:]); ok {
+		h.typ = pType
+		r, err := readTagsAndPalette(h, r, 2, lim)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return h, r, nil
+	}
+
+	h.typ = PaxP8
+	if string(magic[:]) == "GG" {
+		var rest [2]byte
+		if _, err := io.ReadFull(r, rest[:]); err != nil {
+			return nil, nil, decodeError(StageHeader, 0, 0, err)
+		}
+		if string(rest[:]) != "AT" {
+			return nil, nil, decodeError(StageHeader, 0, 0, ErrInvalidMagic)
+		}
+
+		r, err := readTagsAndPalette(h, io.MultiReader(bytes.NewReader([]byte("GGAT")), r), 0, lim)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return h, r, nil
+	}
+
+	// PAC without tags: the first two bytes are already the palette size.
+	// Nothing marks such a file, so it is only accepted when the palette and the
+	// first mip header that follows it are plausible.
+	nColors := binary.LittleEndian.Uint16(magic[:])
+	if nColors == 0 || nColors > maxPaletteColors {
+		return nil, nil, decodeError(StageHeader, 0, 0, ErrInvalidMagic)
+	}
+
+	palette, err := readPalette(r, int(nColors))
+	if err != nil {
+		return nil, nil, decodeError(StageHeader, 0, 0, ErrInvalidMagic)
+	}
+
+	var mip [7]byte
+	if _, err := io.ReadFull(r, mip[:]); err != nil || !plausiblePACMip(mip) {
+		return nil, nil, decodeError(StageHeader, 0, 0, ErrInvalidMagic)
+	}
+
+	h.palette = palette
+	h.mipStart = 2 + int64(len(palette))
+	return h, io.MultiReader(bytes.NewReader(mip[:]), r), nil
+}
+
+// plausiblePACMip reports whether hdr (width, height and 24-bit stored size)
+// can start the first mip of a P8 file: non-zero dimensions without the DXT
+// LZO flag and a stored size that raw or LZSS-compressed indices could have.
+func plausiblePACMip(hdr [7]byte) bool {
+	w := int(binary.LittleEndian.Uint16(hdr[0:2]))
+	h := int(binary.LittleEndian.Uint16(hdr[2:4]))
+	size := int(hdr[4]) | int(hdr[5])<<8 | int(hdr[6])<<16
+	if w == 0 || h == 0 || w&0x8000 != 0 || size == 0 {
+		return false
+	}
+
+	// LZSS spends a flag bit per literal and a 4-byte checksum.
+	raw := w * h
+	return size <= raw+raw/8+8
+}
+
+// readTagsAndPalette reads GGAT tags starting at file offset start, then the palette.
+// It fills h and returns a reader positioned at the first mip block.
+func readTagsAndPalette(h *fileHeader, r io.Reader, start int64, lim *decodeLimits) (io.Reader, error) {
+	tags, tail, err := readGGATTags(r, start, h.typ, lim)
+	if err != nil {
+		return nil, err
+	}
+
+	pos := start
+	for _, t := range tags {
+		pos += 12 + int64(len(t.Data))
+	}
+
+	h.tags = tags
+	h.mipStart = pos + 2
+
+	// The four bytes after the last tag start with the palette triplet count.
+	nColors := int(binary.LittleEndian.Uint16(tail[:2]))
+	if nColors == 0 {
+		// No palette: the remaining two bytes already belong to the first mip.
+		return io.MultiReader(bytes.NewReader(tail[2:]), r), nil
+	}
+	if nColors > maxPaletteColors {
+		return nil, decodeError(StagePalette, h.typ, pos, ErrInvalidMagic)
+	}
+
+	palette, err := readPalette(io.MultiReader(bytes.NewReader(tail[2:]), r), nColors)
+	if err != nil {
+		return nil, decodeError(StagePalette, h.typ, pos, err)
+	}
+
+	h.palette = palette
+	h.mipStart += int64(len(palette))
+	return r, nil
+}
+
+// maxPaletteColors is the largest palette an 8-bit index can address.
+const maxPaletteColors = 256
+
+// readPalette reads nColors palette triplets.
+func readPalette(r io.Reader, nColors int) ([]byte, error) {
+	palette := make([]byte, nColors*3)
+	if _, err := io.ReadFull(r, palette); err != nil {
+		return nil, err
 	}
 
-	return pType, nil
+	return palette, nil
 }
 
-// readGGATTags parses all GGAT tags into map.
-func readGGATTags(r io.Reader) (map[string][]byte, error) {
-	tags := make(map[string][]byte, 8)
+// readGGATTags parses all GGAT tags in file order.
+// start is the file offset of the first tag and is used to fill Tag.Offset.
+// Payload sizes are checked against lim before anything is allocated.
+// It also returns the first four bytes following the tags, which are consumed
+// while looking for the next GGAT signature.
+// Errors are returned as DecodeError with StageTags and the offset of the failing tag.
+func readGGATTags(r io.Reader, start int64, typ PaxType, lim *decodeLimits) (TagList, [4]byte, error) {
+	tags := make(TagList, 0, 8)
+	offset := start
 	for {
 		var sig [4]byte
 		if _, err := io.ReadFull(r, sig[:]); err != nil {
-			return nil, err
+			return nil, sig, decodeError(StageTags, typ, offset, err)
 		}
 
 		if string(sig[:]) != "GGAT" {
-			break
+			return tags, sig, nil
 		}
 
 		var nameBuf [4]byte
 		if _, err := io.ReadFull(r, nameBuf[:]); err != nil {
-			return nil, err
+			return nil, sig, decodeError(StageTags, typ, offset, err)
 		}
 
 		var size uint32
 		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
-			return nil, err
+			return nil, sig, decodeError(StageTags, typ, offset, err)
 		}
 
-		data := make([]byte, size)
-		if _, err := io.ReadFull(r, data); err != nil {
-			return nil, err
+		if err := lim.tag(string(nameBuf[:]), size); err != nil {
+			return nil, sig, decodeError(StageTags, typ, offset, err)
 		}
 
-		tags[string(nameBuf[:])] = data
+		data, err := readPayload(r, int(size))
+		if err != nil {
+			return nil, sig, decodeError(StageTags, typ, offset, err)
+		}
+
+		tags = append(tags, Tag{Name: string(nameBuf[:]), Data: data, Offset: offset})
+		offset += 12 + int64(size)
+	}
+}
+
+// sffoError wraps an SFFO resolution error with the SFFO tag offset.
+func (h *fileHeader) sffoError(err error) error {
+	offset := int64(-1)
+	for _, t := range h.tags {
+		if t.Name == "SFFO" {
+			offset = t.Offset
+		}
 	}
 
-	return tags, nil
+	return decodeError(StageSFFO, h.typ, offset, err)
 }
 
 // sffoOffsets returns non-zero offsets from SFFO tag.
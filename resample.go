@@ -0,0 +1,341 @@
+package paa
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+)
+
+// ResampleFilter selects the kernel used when an image is scaled.
+type ResampleFilter int
+
+const (
+	// ResampleDefault uses ResampleLanczos.
+	ResampleDefault ResampleFilter = iota
+	// ResampleNearest picks the nearest source pixel (no filtering).
+	ResampleNearest
+	// ResampleBox averages the source pixels covered by each output pixel.
+	ResampleBox
+	// ResampleTriangle is a linear (tent) filter; bilinear when upscaling.
+	ResampleTriangle
+	// ResampleCatmullRom is a sharp cubic filter (B=0, C=0.5).
+	ResampleCatmullRom
+	// ResampleLanczos is a windowed sinc filter with three lobes.
+	ResampleLanczos
+	// ResampleKaiser is a Kaiser-windowed sinc (alpha 4, three lobes); sharp
+	// with little ringing.
+	ResampleKaiser
+	// ResampleMitchell is the Mitchell-Netravali cubic (B=C=1/3), a soft
+	// compromise between blur and ringing.
+	ResampleMitchell
+)
+
+// String returns the filter name.
+func (f ResampleFilter) String() string {
+	switch f {
+	case ResampleDefault:
+		return "default"
+	case ResampleNearest:
+		return "nearest"
+	case ResampleBox:
+		return "box"
+	case ResampleTriangle:
+		return "triangle"
+	case ResampleCatmullRom:
+		return "catmull-rom"
+	case ResampleLanczos:
+		return "lanczos"
+	case ResampleKaiser:
+		return "kaiser"
+	case ResampleMitchell:
+		return "mitchell"
+	default:
+		return fmt.Sprintf("ResampleFilter(%d)", int(f))
+	}
+}
+
+// resampleKernel is a symmetric filter kernel with the given support radius.
+type resampleKernel struct {
+	eval    func(x float64) float64
+	support float64
+}
+
+// kernel returns the kernel of f; unknown filters fall back to Lanczos.
+func (f ResampleFilter) kernel() resampleKernel {
+	switch f {
+	case ResampleNearest:
+		return resampleKernel{support: 0}
+	case ResampleBox:
+		return resampleKernel{support: 0.5, eval: func(x float64) float64 {
+			if x >= -0.5 && x < 0.5 {
+				return 1
+			}
+			return 0
+		}}
+	case ResampleTriangle:
+		return resampleKernel{support: 1, eval: func(x float64) float64 {
+			return max(1-math.Abs(x), 0)
+		}}
+	case ResampleCatmullRom:
+		return resampleKernel{support: 2, eval: func(x float64) float64 {
+			return cubicBC(x, 0, 0.5)
+		}}
+	case ResampleMitchell:
+		return resampleKernel{support: 2, eval: func(x float64) float64 {
+			return cubicBC(x, 1.0/3, 1.0/3)
+		}}
+	case ResampleKaiser:
+		return resampleKernel{support: 3, eval: func(x float64) float64 {
+			return sinc(x) * kaiserWindow(x, 3, 4)
+		}}
+	default:
+		return resampleKernel{support: 3, eval: func(x float64) float64 {
+			return lanczos(x, 3)
+		}}
+	}
+}
+
+// cubicBC is the Mitchell-Netravali cubic with parameters b and c.
+func cubicBC(x, b, c float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	default:
+		return 0
+	}
+}
+
+// lanczos is the Lanczos kernel with a lobes.
+func lanczos(x, a float64) float64 {
+	x = math.Abs(x)
+	if x >= a {
+		return 0
+	}
+
+	return sinc(x) * sinc(x/a)
+}
+
+// kaiserWindow is the Kaiser window of half-width n and shape alpha.
+func kaiserWindow(x, n, alpha float64) float64 {
+	t := x / n
+	if t*t >= 1 {
+		return 0
+	}
+
+	return besselI0(alpha*math.Sqrt(1-t*t)) / besselI0(alpha)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	q := x * x / 4
+	for k := 1.0; term > sum*1e-12; k++ {
+		term *= q / (k * k)
+		sum += term
+	}
+
+	return sum
+}
+
+// sinc is the normalized sinc function.
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// resampleTaps lists the source indices and normalized weights of one output sample.
+type resampleTaps struct {
+	index  []int
+	weight []float64
+}
+
+// resampleContribs computes taps for scaling srcN samples to dstN.
+// Samples outside the source repeat the edge sample, or the opposite edge when wrap is set.
+func resampleContribs(srcN, dstN int, k resampleKernel, wrap bool) []resampleTaps {
+	scale := float64(srcN) / float64(dstN)
+	out := make([]resampleTaps, dstN)
+	if k.eval == nil {
+		for i := range out {
+			src := min(int(math.Floor((float64(i)+0.5)*scale)), srcN-1)
+			out[i] = resampleTaps{index: []int{src}, weight: []float64{1}}
+		}
+
+		return out
+	}
+
+	// Downscaling widens the kernel so that every source sample contributes.
+	fscale := max(scale, 1)
+	support := k.support * fscale
+	for i := range out {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo := int(math.Ceil(center - support))
+		hi := int(math.Floor(center + support))
+
+		taps := resampleTaps{}
+		sum := 0.0
+		for j := lo; j <= hi; j++ {
+			w := k.eval((float64(j) - center) / fscale)
+			if w == 0 {
+				continue
+			}
+
+			taps.index = append(taps.index, edgeIndex(j, srcN, wrap))
+			taps.weight = append(taps.weight, w)
+			sum += w
+		}
+		if sum == 0 {
+			// Box kernels can miss every tap when upscaling exactly between samples.
+			taps = resampleTaps{index: []int{edgeIndex(int(math.Round(center)), srcN, wrap)}, weight: []float64{1}}
+			sum = 1
+		}
+		for j := range taps.weight {
+			taps.weight[j] /= sum
+		}
+
+		out[i] = taps
+	}
+
+	return out
+}
+
+// edgeIndex maps sample i into [0, n) by clamping or, with wrap, by tiling.
+func edgeIndex(i, n int, wrap bool) int {
+	if wrap {
+		return ((i % n) + n) % n
+	}
+
+	return min(max(i, 0), n-1)
+}
+
+// resampleParams configures resampleWith.
+type resampleParams struct {
+	filter ResampleFilter
+	// wrap samples across the opposite edge (tileable textures).
+	wrap bool
+	// srgb filters RGB in linear light and converts back to sRGB.
+	srgb bool
+}
+
+// resampleWith scales img to w x h.
+//
+// Colors are weighted by alpha so that transparent pixels do not bleed their
+// (usually black) RGB into visible neighbours.
+func resampleWith(img image.Image, w, h int, rp resampleParams) *image.NRGBA {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	k := rp.filter.kernel()
+	toLinear := identityTable()
+	if rp.srgb {
+		toLinear = srgbTable()
+	}
+
+	// Source as premultiplied float rows.
+	pre := make([]float64, sw*sh*4)
+	for y := 0; y < sh; y++ {
+		so := src.PixOffset(b.Min.X, b.Min.Y+y)
+		for x := 0; x < sw; x, so = x+1, so+4 {
+			a := float64(src.Pix[so+3]) / 255
+			p := (y*sw + x) * 4
+			pre[p+0] = toLinear[src.Pix[so+0]] * a
+			pre[p+1] = toLinear[src.Pix[so+1]] * a
+			pre[p+2] = toLinear[src.Pix[so+2]] * a
+			pre[p+3] = float64(src.Pix[so+3])
+		}
+	}
+
+	// Horizontal pass: sh rows of w pixels.
+	cols := resampleContribs(sw, w, k, rp.wrap)
+	tmp := make([]float64, sh*w*4)
+	for y := 0; y < sh; y++ {
+		row := pre[y*sw*4 : (y+1)*sw*4]
+		for x, taps := range cols {
+			var acc [4]float64
+			for t, i := range taps.index {
+				wt := taps.weight[t]
+				acc[0] += row[i*4+0] * wt
+				acc[1] += row[i*4+1] * wt
+				acc[2] += row[i*4+2] * wt
+				acc[3] += row[i*4+3] * wt
+			}
+			copy(tmp[(y*w+x)*4:], acc[:])
+		}
+	}
+
+	// Vertical pass into the destination, undoing the alpha weighting.
+	rows := resampleContribs(sh, h, k, rp.wrap)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y, taps := range rows {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for t, i := range taps.index {
+				wt := taps.weight[t]
+				p := (i*w + x) * 4
+				acc[0] += tmp[p+0] * wt
+				acc[1] += tmp[p+1] * wt
+				acc[2] += tmp[p+2] * wt
+				acc[3] += tmp[p+3] * wt
+			}
+
+			d := dst.PixOffset(x, y)
+			a := min(max(acc[3], 0), 255)
+			dst.Pix[d+3] = uint8(math.Round(a))
+			if a == 0 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				v := acc[c] * 255 / a
+				if rp.srgb {
+					v = linearToSRGB(v)
+				}
+				dst.Pix[d+c] = uint8(math.Round(min(max(v, 0), 255)))
+			}
+		}
+	}
+
+	return dst
+}
+
+// identityTable maps 8-bit values to themselves as floats.
+var identityTable = sync.OnceValue(func() *[256]float64 {
+	var t [256]float64
+	for i := range t {
+		t[i] = float64(i)
+	}
+
+	return &t
+})
+
+// srgbTable maps 8-bit sRGB values to linear light scaled to 0-255.
+var srgbTable = sync.OnceValue(func() *[256]float64 {
+	var t [256]float64
+	for i := range t {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		t[i] = v * 255
+	}
+
+	return &t
+})
+
+// linearToSRGB converts linear light scaled to 0-255 back to sRGB 0-255.
+func linearToSRGB(v float64) float64 {
+	v = min(max(v/255, 0), 1)
+	if v <= 0.0031308 {
+		return v * 12.92 * 255
+	}
+
+	return (1.055*math.Pow(v, 1/2.4) - 0.055) * 255
+}
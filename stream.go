@@ -0,0 +1,210 @@
+package paa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamDecoder reads a PAA file forward-only, one mip block at a time.
+//
+// Mip blocks follow the tags and palette sequentially, so the decoder never
+// seeks and never buffers the whole file: peak memory is one mip level. SFFO is
+// only compared with where each mip actually starts; a mismatch does not stop
+// decoding and is reported by SFFOError.
+type StreamDecoder struct {
+	r       *countingReader
+	header  *fileHeader
+	file    *PAA
+	taggs   map[string][]byte
+	lim     *decodeLimits
+	sffoErr error
+	offsets []uint32
+	lastOff int64
+	level   int
+	done    bool
+}
+
+// NewStreamDecoder reads the PAA header (pax type, tags, palette) from r and
+// returns a decoder positioned at the first mip block.
+func NewStreamDecoder(r io.Reader) (*StreamDecoder, error) {
+	return NewStreamDecoderWithOptions(r, nil)
+}
+
+// NewStreamDecoderWithOptions is NewStreamDecoder with resource limits from opts
+// applied to the header and every mip read by Next and Skip.
+func NewStreamDecoderWithOptions(r io.Reader, opts *DecodeOptions) (*StreamDecoder, error) {
+	lim := newDecodeLimits(opts)
+	h, mr, err := readHeader(r, lim)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &StreamDecoder{
+		r:      &countingReader{r: mr, n: h.mipStart},
+		header: h,
+		taggs:  h.tags.Map(),
+		lim:    lim,
+	}
+	d.file = &PAA{
+		Type:    h.typ,
+		Taggs:   d.taggs,
+		Tags:    h.tags,
+		Palette: h.palette,
+	}
+
+	// SFFO is optional here: without it offsets are not validated.
+	if offsets, err := sffoOffsets(d.taggs); err == nil {
+		d.offsets = offsets
+	}
+
+	return d, nil
+}
+
+// Type returns the pax type from the file header.
+func (d *StreamDecoder) Type() PaxType {
+	return d.header.typ
+}
+
+// Tags returns GGAT tags in file order.
+func (d *StreamDecoder) Tags() TagList {
+	return d.header.tags
+}
+
+// Taggs returns GGAT tags keyed by name.
+func (d *StreamDecoder) Taggs() map[string][]byte {
+	return d.taggs
+}
+
+// Palette returns raw palette triplets (BGR), empty for non-indexed formats.
+func (d *StreamDecoder) Palette() []byte {
+	return d.header.palette
+}
+
+// Next reads and decompresses the next mip level.
+// It returns io.EOF after the last mip (terminator block or end of stream).
+func (d *StreamDecoder) Next() (*MipMap, error) {
+	b, err := d.nextStored()
+	if err != nil {
+		return nil, err
+	}
+
+	mm, err := d.decompress(b)
+	if err != nil {
+		return nil, err
+	}
+
+	mm.file = d.file
+	return mm, nil
+}
+
+// SFFOError returns the first mismatch found so far between the SFFO table and
+// the mip blocks read, wrapping ErrSFFOMismatch, or nil. It is complete once
+// Next or Skip has returned io.EOF.
+func (d *StreamDecoder) SFFOError() error {
+	return d.sffoErr
+}
+
+// Skip advances past the next mip level without decompressing it.
+// It returns io.EOF after the last mip, like Next.
+func (d *StreamDecoder) Skip() error {
+	_, err := d.nextStored()
+	return err
+}
+
+// nextStored reads the next mip block without decompressing it.
+func (d *StreamDecoder) nextStored() (*storedMip, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+
+	offset := d.r.n
+	if d.level < len(d.offsets) && int64(d.offsets[d.level]) != offset {
+		err := fmt.Errorf("%w: mip %d at offset %d, SFFO says %d", ErrSFFOMismatch, d.level, offset, d.offsets[d.level])
+		d.sffoMismatch(mipError(err, d.header.typ, d.level, offset))
+	}
+
+	b, err := readStoredMip(d.r, d.header.typ, d.lim)
+	if err == io.EOF && d.r.n == offset {
+		// Stream ended cleanly without a terminator block.
+		b, err = nil, nil
+	}
+	if err != nil {
+		return nil, mipError(err, d.header.typ, d.level, offset)
+	}
+
+	if b == nil {
+		d.done = true
+		if d.level < len(d.offsets) {
+			err := fmt.Errorf("%w: %d mips read, SFFO lists %d", ErrSFFOMismatch, d.level, len(d.offsets))
+			d.sffoMismatch(mipError(err, d.header.typ, d.level, offset))
+		}
+
+		return nil, io.EOF
+	}
+
+	d.level++
+	d.lastOff = offset
+	return b, nil
+}
+
+// sffoMismatch records err unless an earlier mismatch was already found.
+func (d *StreamDecoder) sffoMismatch(err error) {
+	if d.sffoErr == nil {
+		d.sffoErr = err
+	}
+}
+
+// decompress decompresses b, the block last returned by nextStored.
+func (d *StreamDecoder) decompress(b *storedMip) (*MipMap, error) {
+	mm, err := b.decompress(d.header.typ, d.lim)
+	if err != nil {
+		return nil, mipError(err, d.header.typ, d.level-1, d.lastOff)
+	}
+
+	return mm, nil
+}
+
+// DecodePAAStream reads a full PAA structure sequentially, without seeking and
+// without buffering the raw file. See StreamDecoder. DerivedOffsets is set when
+// SFFO is missing or does not match the mip blocks. A stream without mip
+// blocks fails with ErrNoMipmaps, like DecodePAA.
+func DecodePAAStream(r io.Reader) (*PAA, error) {
+	d, err := NewStreamDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := d.file
+	for {
+		mm, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.MipMaps = append(p.MipMaps, mm)
+	}
+
+	if len(p.MipMaps) == 0 {
+		return nil, errors.Join(ErrNoMipmaps, d.sffoErr)
+	}
+
+	p.DerivedOffsets = d.offsets == nil || d.sffoErr != nil
+	return p, nil
+}
+
+// countingReader tracks the absolute file offset of the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
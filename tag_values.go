@@ -0,0 +1,156 @@
+package paa
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/woozymasta/paa/texconfig"
+)
+
+// AlphaFlags is the GALF tag value describing how the texture uses alpha.
+type AlphaFlags uint8
+
+const (
+	// AlphaInterpolated marks textures with smooth (blended) alpha.
+	AlphaInterpolated AlphaFlags = 1
+	// AlphaBinary marks textures whose alpha is only 0 or 255 (alpha test);
+	// detail maps use it as well.
+	AlphaBinary AlphaFlags = 2
+)
+
+// String returns "interpolated", "binary" or the numeric value.
+func (f AlphaFlags) String() string {
+	switch f {
+	case AlphaInterpolated:
+		return "interpolated"
+	case AlphaBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("AlphaFlags(%d)", uint8(f))
+	}
+}
+
+// AverageColor returns the CGVA tag (stored BGRA) as a color.
+// ok is false when the tag is missing or not 4 bytes long.
+func (p *PAA) AverageColor() (c color.NRGBA, ok bool) {
+	return bgraTag(p.tagMap(), "CGVA")
+}
+
+// MaxColor returns the CXAM tag (stored BGRA) as a color.
+// ok is false when the tag is missing or not 4 bytes long.
+func (p *PAA) MaxColor() (c color.NRGBA, ok bool) {
+	return bgraTag(p.tagMap(), "CXAM")
+}
+
+// AlphaFlags returns the GALF tag value. ok is false when the tag is missing or empty.
+func (p *PAA) AlphaFlags() (f AlphaFlags, ok bool) {
+	return galfTag(p.tagMap())
+}
+
+// Swizzle returns the channel swizzle stored in the ZIWS tag.
+// ok is false when the tag is missing or malformed.
+func (p *PAA) Swizzle() (s texconfig.ChannelSwizzle, ok bool) {
+	return ziwsTag(p.tagMap())
+}
+
+// MipOffsets returns the non-zero mip offsets from the SFFO tag.
+// It fails with ErrMissingSFFO when the tag is missing or all zeros.
+func (p *PAA) MipOffsets() ([]uint32, error) {
+	return sffoOffsets(p.tagMap())
+}
+
+// SetAverageColor writes c to the CGVA tag in BGRA order.
+func (p *PAA) SetAverageColor(c color.NRGBA) {
+	p.SetTag("CGVA", []byte{c.B, c.G, c.R, c.A})
+}
+
+// SetMaxColor writes c to the CXAM tag in BGRA order.
+func (p *PAA) SetMaxColor(c color.NRGBA) {
+	p.SetTag("CXAM", []byte{c.B, c.G, c.R, c.A})
+}
+
+// SetAlphaFlags writes f to the GALF tag; 0 removes the tag.
+func (p *PAA) SetAlphaFlags(f AlphaFlags) {
+	if f == 0 {
+		p.DeleteTag("GALF")
+		return
+	}
+
+	p.SetTag("GALF", []byte{byte(f), 0, 0, 0})
+}
+
+// SetSwizzle writes s to the ZIWS tag; an identity swizzle removes the tag.
+// Only the mapping is stored, mip pixels are not changed.
+func (p *PAA) SetSwizzle(s texconfig.ChannelSwizzle) error {
+	tag, ok, err := s.ZIWSTag()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		p.DeleteTag("ZIWS")
+		return nil
+	}
+
+	p.SetTag("ZIWS", tag[:])
+	return nil
+}
+
+// AverageColor returns the CGVA tag (stored BGRA) as a color. See PAA.AverageColor.
+func (m *Metadata) AverageColor() (c color.NRGBA, ok bool) {
+	return bgraTag(m.Taggs, "CGVA")
+}
+
+// MaxColor returns the CXAM tag (stored BGRA) as a color. See PAA.MaxColor.
+func (m *Metadata) MaxColor() (c color.NRGBA, ok bool) {
+	return bgraTag(m.Taggs, "CXAM")
+}
+
+// AlphaFlags returns the GALF tag value. See PAA.AlphaFlags.
+func (m *Metadata) AlphaFlags() (f AlphaFlags, ok bool) {
+	return galfTag(m.Taggs)
+}
+
+// Swizzle returns the channel swizzle stored in the ZIWS tag. See PAA.Swizzle.
+func (m *Metadata) Swizzle() (s texconfig.ChannelSwizzle, ok bool) {
+	return ziwsTag(m.Taggs)
+}
+
+// MipOffsets returns the non-zero mip offsets from the SFFO tag. See PAA.MipOffsets.
+func (m *Metadata) MipOffsets() ([]uint32, error) {
+	return sffoOffsets(m.Taggs)
+}
+
+// bgraTag decodes a 4-byte BGRA color tag.
+func bgraTag(taggs map[string][]byte, name string) (color.NRGBA, bool) {
+	data, ok := taggs[name]
+	if !ok || len(data) != 4 {
+		return color.NRGBA{}, false
+	}
+
+	return color.NRGBA{R: data[2], G: data[1], B: data[0], A: data[3]}, true
+}
+
+// galfTag decodes the GALF flag value from the low byte of the tag.
+func galfTag(taggs map[string][]byte) (AlphaFlags, bool) {
+	data, ok := taggs["GALF"]
+	if !ok || len(data) == 0 {
+		return 0, false
+	}
+
+	return AlphaFlags(data[0]), true
+}
+
+// ziwsTag decodes the ZIWS tag, whose bytes hold A, R, G, B selectors.
+func ziwsTag(taggs map[string][]byte) (texconfig.ChannelSwizzle, bool) {
+	data, ok := taggs["ZIWS"]
+	if !ok || len(data) != 4 {
+		return texconfig.ChannelSwizzle{}, false
+	}
+
+	s, err := texconfig.ParseZIWSTag([4]byte(data))
+	if err != nil {
+		return texconfig.ChannelSwizzle{}, false
+	}
+
+	return s, true
+}
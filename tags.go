@@ -0,0 +1,143 @@
+package paa
+
+// Tag is a single GGAT entry as stored in the file.
+type Tag struct {
+	// Name is the 4-byte tag identifier (e.g. "CGVA", "SFFO").
+	Name string
+	// Data is the raw tag payload.
+	Data []byte
+	// Offset is the file offset of the GGAT signature (0 for tags not read from a file).
+	Offset int64
+}
+
+// TagList is an ordered list of GGAT tags.
+// It preserves on-disk order, duplicates and tags unknown to this package.
+type TagList []Tag
+
+// knownTags lists tags generated by this package on encode.
+var knownTags = map[string]bool{
+	"CGVA": true,
+	"CXAM": true,
+	"GALF": true,
+	"ZIWS": true,
+	"SFFO": true,
+}
+
+// Get returns the payload of the last tag with the given name.
+func (l TagList) Get(name string) ([]byte, bool) {
+	for i := len(l) - 1; i >= 0; i-- {
+		if l[i].Name == name {
+			return l[i].Data, true
+		}
+	}
+
+	return nil, false
+}
+
+// Set replaces the payload of the last tag with the given name, or adds a new tag.
+// New tags are inserted before SFFO so that the offset table stays last.
+func (l *TagList) Set(name string, data []byte) {
+	for i := len(*l) - 1; i >= 0; i-- {
+		if (*l)[i].Name == name {
+			(*l)[i].Data = data
+			return
+		}
+	}
+
+	t := Tag{Name: name, Data: data}
+	for i, existing := range *l {
+		if existing.Name == "SFFO" {
+			*l = append((*l)[:i], append(TagList{t}, (*l)[i:]...)...)
+			return
+		}
+	}
+
+	*l = append(*l, t)
+}
+
+// Delete removes all tags with the given name.
+func (l *TagList) Delete(name string) {
+	out := (*l)[:0]
+	for _, t := range *l {
+		if t.Name != name {
+			out = append(out, t)
+		}
+	}
+
+	*l = out
+}
+
+// Map returns tags keyed by name. For duplicate names the last tag wins.
+func (l TagList) Map() map[string][]byte {
+	m := make(map[string][]byte, len(l))
+	for _, t := range l {
+		m[t.Name] = t.Data
+	}
+
+	return m
+}
+
+// Unknown returns tags that this package does not generate itself (anything except
+// CGVA, CXAM, GALF, ZIWS and SFFO), in original order. Useful as EncodeOptions.ExtraTags
+// when re-encoding a decoded texture.
+func (l TagList) Unknown() TagList {
+	var out TagList
+	for _, t := range l {
+		if !knownTags[t.Name] {
+			out = append(out, t)
+		}
+	}
+
+	return out
+}
+
+// SetTag sets a tag payload in both Taggs and Tags, keeping them in sync.
+func (p *PAA) SetTag(name string, data []byte) {
+	if p.Taggs == nil {
+		p.Taggs = make(map[string][]byte, 8)
+	}
+
+	p.Taggs[name] = data
+	p.Tags.Set(name, data)
+}
+
+// DeleteTag removes a tag from both Taggs and Tags.
+func (p *PAA) DeleteTag(name string) {
+	delete(p.Taggs, name)
+	p.Tags.Delete(name)
+}
+
+// tagMap returns the tags of p keyed by name: a view of Tags when it is set,
+// otherwise Taggs.
+func (p *PAA) tagMap() map[string][]byte {
+	if len(p.Tags) == 0 {
+		return p.Taggs
+	}
+
+	return p.Tags.Map()
+}
+
+// tagEntries returns tags to write for p.
+//
+// Tags is written as is (order, duplicates and unknown tags included), with an
+// SFFO entry appended when it has none. Without Tags, Taggs is written in
+// canonical order.
+func (p *PAA) tagEntries() []tagEntry {
+	if len(p.Tags) == 0 {
+		return mapTagEntries(p.Taggs)
+	}
+
+	out := make([]tagEntry, 0, len(p.Tags)+1)
+	hasSFFO := false
+	for _, t := range p.Tags {
+		if t.Name == "SFFO" {
+			hasSFFO = true
+		}
+		out = append(out, tagEntry{name: t.Name, data: t.Data})
+	}
+	if !hasSFFO {
+		out = append(out, tagEntry{name: "SFFO"})
+	}
+
+	return out
+}
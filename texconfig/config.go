@@ -30,6 +30,13 @@ type TexConvertConfig struct {
 	// This is an extension over TexConvert.cfg behavior.
 	UseSRGBFromDynRange bool `json:"use_srgb_from_dyn_range,omitempty" yaml:"use_srgb_from_dyn_range,omitempty"`
 
+	// ExpandDynRange applies DynRange as color range expansion: colors are
+	// stretched to the full range and CXAM stores the scale. It is opt-in (off
+	// in the default config): such files only decode to the original colors
+	// with paa.DecodeOptions.ApplyMaxColor.
+	// This is an extension over TexConvert.cfg behavior.
+	ExpandDynRange bool `json:"expand_dyn_range,omitempty" yaml:"expand_dyn_range,omitempty"`
+
 	// DisableAutoReduce disables AutoReduce even when hints request it.
 	// This is an extension over TexConvert.cfg behavior.
 	DisableAutoReduce bool `json:"disable_autoreduce,omitempty" yaml:"disable_autoreduce,omitempty"`
@@ -80,14 +87,17 @@ type TextureHint struct {
 
 	// LimitSize is the max dimension limit from config (0 = no limit).
 	LimitSize int `json:"limit_size,omitempty" yaml:"limit_size,omitempty"`
+
+	// AlphaCoverageRef is the alpha-test reference (1-255) whose coverage is
+	// preserved in every mip; 0 disables it.
+	// This is an extension over TexConvert.cfg behavior (alphaCoverageRef).
+	AlphaCoverageRef int `json:"alpha_coverage_ref,omitempty" yaml:"alpha_coverage_ref,omitempty"`
 }
 
 // Clone returns a deep copy of the config.
 func (c TexConvertConfig) Clone() TexConvertConfig {
-	out := TexConvertConfig{
-		ConvertVersion: c.ConvertVersion,
-		Hints:          make([]TextureHint, len(c.Hints)),
-	}
+	out := c
+	out.Hints = make([]TextureHint, len(c.Hints))
 	copy(out.Hints, c.Hints)
 
 	return out
@@ -235,6 +235,17 @@ func hintFromProps(cls *cfgClass, props map[string]cfgValue) (TextureHint, error
 		h.LimitSize = n
 	}
 
+	if v, ok := props["alphaCoverageRef"]; ok {
+		n, err := cfgInt(v)
+		if err != nil {
+			return TextureHint{}, fmt.Errorf("alphaCoverageRef in %s: %w", cls.Name, err)
+		}
+		if n < 0 || n > 255 {
+			return TextureHint{}, fmt.Errorf("alphaCoverageRef in %s: %d out of range 0-255", cls.Name, n)
+		}
+		h.AlphaCoverageRef = n
+	}
+
 	if v, ok := props["channelSwizzleR"]; ok {
 		expr, err := ParseSwizzleExpr(v.String())
 		if err != nil {
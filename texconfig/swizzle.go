@@ -6,11 +6,25 @@ import (
 )
 
 // ApplyChannelSwizzle returns a new NRGBA image with the swizzle applied.
-// It converts the input to NRGBA before channel mapping.
+// It converts the input to NRGBA before channel mapping; *image.NRGBA input
+// is read from Pix directly.
 func ApplyChannelSwizzle(img image.Image, swz ChannelSwizzle) *image.NRGBA {
 	b := img.Bounds()
 	out := image.NewNRGBA(b)
 
+	if src, ok := img.(*image.NRGBA); ok {
+		w := b.Dx() * 4
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			sp := src.Pix[src.PixOffset(b.Min.X, y):][:w]
+			dp := out.Pix[out.PixOffset(b.Min.X, y):][:w]
+			for i := 0; i < w; i += 4 {
+				dp[i+0], dp[i+1], dp[i+2], dp[i+3] = swz.apply(sp[i+0], sp[i+1], sp[i+2], sp[i+3])
+			}
+		}
+
+		return out
+	}
+
 	// Iterate over image
 	for y := b.Min.Y; y < b.Max.Y; y++ {
 		// Iterate over row
@@ -362,6 +362,15 @@ func (e SwizzleExpr) String() string {
 	return src
 }
 
+// stringOr returns String, or the name of def when the expression is unset.
+func (e SwizzleExpr) stringOr(def SwizzleSource) string {
+	if !e.Valid {
+		return def.String()
+	}
+
+	return e.String()
+}
+
 // MarshalText implements encoding.TextMarshaler.
 func (e SwizzleExpr) MarshalText() ([]byte, error) {
 	if !e.Valid {
@@ -452,6 +461,13 @@ func (s ChannelSwizzle) apply(r, g, b, a byte) (byte, byte, byte, byte) {
 	return r2, g2, b2, a2
 }
 
+// String formats the swizzle as "R=<expr> G=<expr> B=<expr> A=<expr>",
+// e.g. "R=1-A G=G B=B A=1-R". Unset channels print their default source.
+func (s ChannelSwizzle) String() string {
+	return "R=" + s.R.stringOr(SwizzleR) + " G=" + s.G.stringOr(SwizzleG) +
+		" B=" + s.B.stringOr(SwizzleB) + " A=" + s.A.stringOr(SwizzleA)
+}
+
 // ZIWSTag returns the SWIZTAGG payload for this swizzle.
 // The 4 bytes map to A, R, G, B swizzle selectors used by BI tools.
 func (s ChannelSwizzle) ZIWSTag() ([4]byte, bool, error) {
@@ -501,6 +517,38 @@ const (
 	ziwsZero = 0x09
 )
 
+// ParseZIWSTag converts a SWIZTAGG payload back into a swizzle; it is the
+// inverse of ChannelSwizzle.ZIWSTag. The 4 bytes hold A, R, G, B selectors:
+// 0-3 pick A/R/G/B, 4-7 pick the inverted channel, 0x08 is 1 and 0x09 is 0.
+func ParseZIWSTag(tag [4]byte) (ChannelSwizzle, error) {
+	var exprs [4]SwizzleExpr
+	for i, sel := range tag {
+		expr, ok := ziwsExpr(sel)
+		if !ok {
+			return ChannelSwizzle{}, fmt.Errorf("unknown ZIWS selector 0x%02x at byte %d", sel, i)
+		}
+
+		exprs[i] = expr
+	}
+
+	return ChannelSwizzle{A: exprs[0], R: exprs[1], G: exprs[2], B: exprs[3]}, nil
+}
+
+// ziwsExpr returns the swizzle expression for a ZIWS selector byte.
+func ziwsExpr(sel byte) (SwizzleExpr, bool) {
+	switch {
+	case sel == ziwsOne:
+		return SwizzleExpr{Valid: true, IsConst: true, ConstValue: 255}, true
+	case sel == ziwsZero:
+		return SwizzleExpr{Valid: true, IsConst: true}, true
+	case sel > ziwsZero:
+		return SwizzleExpr{}, false
+	}
+
+	sources := [4]SwizzleSource{SwizzleA, SwizzleR, SwizzleG, SwizzleB}
+	return SwizzleExpr{Valid: true, Source: sources[sel&0x03], Invert: sel >= ziwsInvA}, true
+}
+
 // ziwsValue returns the value of the given swizzle expression.
 func ziwsValue(expr SwizzleExpr, def SwizzleSource) (byte, bool) {
 	if !expr.Valid {
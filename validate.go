@@ -0,0 +1,577 @@
+package paa
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// IssueKind classifies a problem reported by Validate and ValidateFile.
+type IssueKind int
+
+const (
+	// IssueNoMipmaps: the texture has no mip levels.
+	IssueNoMipmaps IssueKind = iota + 1
+	// IssueNonPowerOfTwo: a mip width or height is not a power of two.
+	IssueNonPowerOfTwo
+	// IssueMipChain: a mip is not half the size (rounded down, min 1) of the previous one.
+	IssueMipChain
+	// IssueTooManyMips: more mips than the 16 SFFO entries can address.
+	IssueTooManyMips
+	// IssueMipSize: decompressed mip data does not match the size implied by dimensions.
+	IssueMipSize
+	// IssueMipDecode: mip payload failed to decompress or decode.
+	IssueMipDecode
+	// IssueTruncated: the file ends inside a mip block.
+	IssueTruncated
+	// IssueMissingTerminator: the mip chain is not closed by the zero terminator block.
+	IssueMissingTerminator
+	// IssueTrailingData: bytes follow the terminator block.
+	IssueTrailingData
+	// IssueTagLength: a tag payload has the wrong length.
+	IssueTagLength
+	// IssueTagName: a tag name is not 4 bytes long.
+	IssueTagName
+	// IssueDuplicateTag: a tag appears more than once.
+	IssueDuplicateTag
+	// IssueSFFOMissing: SFFO is missing or has no non-zero offsets.
+	IssueSFFOMissing
+	// IssueSFFOPastEOF: an SFFO offset points past the end of the file.
+	IssueSFFOPastEOF
+	// IssueSFFOOrder: SFFO offsets are not strictly increasing.
+	IssueSFFOOrder
+	// IssueSFFOMismatch: SFFO offsets do not match the mip blocks in the file.
+	IssueSFFOMismatch
+	// IssueAverageColor: CGVA differs from the plain and the gamma 2.2
+	// average color of the first mip. BI tools write fixed or differently
+	// weighted CGVA for some texture types, so this is a warning.
+	IssueAverageColor
+)
+
+// String returns a short name of the issue kind.
+func (k IssueKind) String() string {
+	switch k {
+	case IssueNoMipmaps:
+		return "no mipmaps"
+	case IssueNonPowerOfTwo:
+		return "non-power-of-two size"
+	case IssueMipChain:
+		return "mip chain"
+	case IssueTooManyMips:
+		return "too many mips"
+	case IssueMipSize:
+		return "mip size"
+	case IssueMipDecode:
+		return "mip decode"
+	case IssueTruncated:
+		return "truncated"
+	case IssueMissingTerminator:
+		return "missing terminator"
+	case IssueTrailingData:
+		return "trailing data"
+	case IssueTagLength:
+		return "tag length"
+	case IssueTagName:
+		return "tag name"
+	case IssueDuplicateTag:
+		return "duplicate tag"
+	case IssueSFFOMissing:
+		return "SFFO missing"
+	case IssueSFFOPastEOF:
+		return "SFFO past EOF"
+	case IssueSFFOOrder:
+		return "SFFO order"
+	case IssueSFFOMismatch:
+		return "SFFO mismatch"
+	case IssueAverageColor:
+		return "average color"
+	default:
+		return fmt.Sprintf("IssueKind(%d)", int(k))
+	}
+}
+
+// Severity ranks an issue kind.
+type Severity int
+
+const (
+	// SeverityError marks files the engine may fail to load or render correctly.
+	SeverityError Severity = iota + 1
+	// SeverityWarning marks deviations that are usually harmless.
+	SeverityWarning
+)
+
+// String returns "error" or "warning".
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+
+	return "error"
+}
+
+// Severity returns SeverityWarning for advisory kinds (average color, trailing
+// data, duplicate tags) and SeverityError for the rest.
+func (k IssueKind) Severity() Severity {
+	switch k {
+	case IssueAverageColor, IssueTrailingData, IssueDuplicateTag:
+		return SeverityWarning
+	default:
+		return SeverityError
+	}
+}
+
+// Issue is a single problem found by Validate or ValidateFile.
+type Issue struct {
+	// Message describes the problem.
+	Message string
+	// Tag is the tag name for tag and SFFO issues, empty otherwise.
+	Tag string
+	// Offset is the file offset of the affected mip block or tag, -1 when
+	// unknown (always for Validate, which has no file).
+	Offset int64
+	// Mip is the affected mip level, -1 when the issue is not mip-specific.
+	Mip int
+	// Kind classifies the issue.
+	Kind IssueKind
+}
+
+// Severity returns the severity of the issue kind.
+func (i Issue) Severity() Severity {
+	return i.Kind.Severity()
+}
+
+// String formats the issue as "severity: kind: message".
+func (i Issue) String() string {
+	return i.Severity().String() + ": " + i.Kind.String() + ": " + i.Message
+}
+
+// HasErrors reports whether any issue has SeverityError.
+func HasErrors(issues []Issue) bool {
+	for _, i := range issues {
+		if i.Severity() == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cgvaTolerance is the per-channel difference allowed between CGVA and the
+// decoded average of the first mip (block compression shifts the average).
+const cgvaTolerance = 8
+
+// maxSFFOEntries is the number of mip offsets an SFFO tag can hold.
+const maxSFFOEntries = 16
+
+// fixedTagSizes lists tags with a fixed payload length.
+var fixedTagSizes = map[string]int{
+	"CGVA": 4,
+	"CXAM": 4,
+	"GALF": 4,
+	"ZIWS": 4,
+}
+
+// Validate checks a decoded PAA for structural problems: mip sizes and chain,
+// tag payload lengths and CGVA against the decoded first mip.
+// SFFO is not checked since WriteTo recomputes it; use ValidateFile for that.
+// An empty result means no issues were found.
+func Validate(p *PAA) []Issue {
+	entries := p.tagEntries()
+	tags := make(TagList, 0, len(entries))
+	for _, e := range entries {
+		if e.name != "SFFO" {
+			tags = append(tags, Tag{Name: e.name, Data: e.data, Offset: -1})
+		}
+	}
+
+	issues := validateTags(tags)
+
+	sizes := make([]image.Point, len(p.MipMaps))
+	for i, mm := range p.MipMaps {
+		sizes[i] = image.Pt(int(mm.Width), int(mm.Height))
+	}
+	issues = append(issues, validateMipChain(sizes, nil)...)
+
+	for i, mm := range p.MipMaps {
+		want := expectedMipSize(mm.Type, int(mm.Width), int(mm.Height))
+		if want >= 0 && len(mm.Data) != want {
+			issues = append(issues, Issue{
+				Kind:    IssueMipSize,
+				Mip:     i,
+				Offset:  -1,
+				Message: fmt.Sprintf("mip %d has %d bytes, want %d", i, len(mm.Data), want),
+			})
+		}
+	}
+
+	if len(p.MipMaps) > 0 {
+		issues = append(issues, validateAverageColor(p, p.MipMaps[0])...)
+	}
+
+	return issues
+}
+
+// ValidateFile checks a PAA file: everything Validate checks, plus SFFO offsets
+// against the actual mip blocks and file size, truncation, the terminator block
+// and trailing bytes. Mip payloads are decompressed to find corrupt blocks.
+//
+// An error is returned only when the header cannot be parsed or a resource
+// limit is exceeded (ErrLimitExceeded); all other problems are reported as
+// issues.
+func ValidateFile(r io.Reader) ([]Issue, error) {
+	return ValidateFileWithOptions(r, nil)
+}
+
+// ValidateFileWithOptions is ValidateFile with resource limits from opts (see
+// DecodeOptions). A file exceeding them is not checked further and the
+// ErrLimitExceeded error is returned instead of issues.
+func ValidateFileWithOptions(r io.Reader, opts *DecodeOptions) ([]Issue, error) {
+	lim := newDecodeLimits(opts)
+	r, seeker, err := ensureSeeker(r, lim)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	h, mr, err := readHeader(r, lim)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := validateTags(h.tags)
+	p := &PAA{Type: h.typ, Taggs: h.tags.Map(), Tags: h.tags, Palette: h.palette}
+
+	// Walk the mip chain as stored.
+	cr := &countingReader{r: mr, n: h.mipStart}
+	var sizes []image.Point
+	var offsets []int64
+	var first *MipMap
+	terminated := false
+	for {
+		offset := cr.n
+		b, err := readStoredMip(cr, h.typ, lim)
+		if err == io.EOF && cr.n == offset {
+			break
+		}
+		if errors.Is(err, ErrLimitExceeded) {
+			return nil, mipError(err, h.typ, len(sizes), offset)
+		}
+		if err != nil {
+			issues = append(issues, Issue{
+				Kind:    IssueTruncated,
+				Mip:     len(sizes),
+				Offset:  offset,
+				Message: fmt.Sprintf("mip %d at offset %d: %v", len(sizes), offset, err),
+			})
+			break
+		}
+		if b == nil {
+			// The terminator block is 6 zero bytes; width and height were read above.
+			var pad [2]byte
+			if _, err := io.ReadFull(cr, pad[:]); err == nil {
+				terminated = true
+			}
+			break
+		}
+
+		level := len(sizes)
+		sizes = append(sizes, image.Pt(int(b.width), int(b.height)))
+		offsets = append(offsets, offset)
+
+		mm, err := b.decompress(h.typ, lim)
+		if errors.Is(err, ErrLimitExceeded) {
+			return nil, mipError(err, h.typ, level, offset)
+		}
+		if err != nil {
+			issues = append(issues, Issue{
+				Kind:    IssueMipDecode,
+				Mip:     level,
+				Offset:  offset,
+				Message: fmt.Sprintf("mip %d at offset %d: %v", level, offset, err),
+			})
+			continue
+		}
+		if level == 0 {
+			first = mm
+		}
+	}
+
+	issues = append(issues, validateMipChain(sizes, offsets)...)
+	issues = append(issues, validateSFFO(h, offsets, size)...)
+
+	if !terminated {
+		issues = append(issues, Issue{
+			Kind:    IssueMissingTerminator,
+			Mip:     -1,
+			Offset:  cr.n,
+			Message: fmt.Sprintf("mip chain ends at offset %d without terminator block", cr.n),
+		})
+	} else if cr.n < size {
+		issues = append(issues, Issue{
+			Kind:    IssueTrailingData,
+			Mip:     -1,
+			Offset:  cr.n,
+			Message: fmt.Sprintf("%d bytes after terminator block", size-cr.n),
+		})
+	}
+
+	if first != nil {
+		issues = append(issues, validateAverageColor(p, first)...)
+	}
+
+	return issues, nil
+}
+
+// validateTags checks tag names, fixed payload lengths, SFFO length and duplicates.
+func validateTags(tags TagList) []Issue {
+	var issues []Issue
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		if len(t.Name) != 4 {
+			issues = append(issues, Issue{
+				Kind:    IssueTagName,
+				Mip:     -1,
+				Tag:     t.Name,
+				Offset:  t.Offset,
+				Message: fmt.Sprintf("tag name %q is not 4 bytes", t.Name),
+			})
+		}
+
+		if seen[t.Name] {
+			issues = append(issues, Issue{
+				Kind:    IssueDuplicateTag,
+				Mip:     -1,
+				Tag:     t.Name,
+				Offset:  t.Offset,
+				Message: fmt.Sprintf("tag %s appears more than once", t.Name),
+			})
+		}
+		seen[t.Name] = true
+
+		want, fixed := fixedTagSizes[t.Name]
+		switch {
+		case fixed && len(t.Data) != want:
+			issues = append(issues, Issue{
+				Kind:    IssueTagLength,
+				Mip:     -1,
+				Tag:     t.Name,
+				Offset:  t.Offset,
+				Message: fmt.Sprintf("tag %s has %d bytes, want %d", t.Name, len(t.Data), want),
+			})
+		case t.Name == "SFFO" && (len(t.Data)%4 != 0 || len(t.Data) > maxSFFOEntries*4):
+			issues = append(issues, Issue{
+				Kind:    IssueTagLength,
+				Mip:     -1,
+				Tag:     t.Name,
+				Offset:  t.Offset,
+				Message: fmt.Sprintf("tag SFFO has %d bytes, want a multiple of 4 up to %d", len(t.Data), maxSFFOEntries*4),
+			})
+		}
+	}
+
+	return issues
+}
+
+// validateMipChain checks mip count, power-of-two sizes and halving.
+// offsets, when not nil, holds the file offset of each mip.
+func validateMipChain(sizes []image.Point, offsets []int64) []Issue {
+	if len(sizes) == 0 {
+		return []Issue{{Kind: IssueNoMipmaps, Mip: -1, Offset: -1, Message: "texture has no mip levels"}}
+	}
+
+	var issues []Issue
+	offsetOf := func(i int) int64 {
+		if offsets == nil {
+			return -1
+		}
+		return offsets[i]
+	}
+
+	if len(sizes) > maxSFFOEntries {
+		issues = append(issues, Issue{
+			Kind:    IssueTooManyMips,
+			Mip:     -1,
+			Offset:  -1,
+			Message: fmt.Sprintf("%d mips, SFFO holds at most %d", len(sizes), maxSFFOEntries),
+		})
+	}
+
+	for i, s := range sizes {
+		if !isPowerOfTwo(s.X) || !isPowerOfTwo(s.Y) {
+			issues = append(issues, Issue{
+				Kind:    IssueNonPowerOfTwo,
+				Mip:     i,
+				Offset:  offsetOf(i),
+				Message: fmt.Sprintf("mip %d is %dx%d", i, s.X, s.Y),
+			})
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		want := image.Pt(max(1, sizes[i-1].X/2), max(1, sizes[i-1].Y/2))
+		if s != want {
+			issues = append(issues, Issue{
+				Kind:    IssueMipChain,
+				Mip:     i,
+				Offset:  offsetOf(i),
+				Message: fmt.Sprintf("mip %d is %dx%d, want %dx%d", i, s.X, s.Y, want.X, want.Y),
+			})
+		}
+	}
+
+	return issues
+}
+
+// validateSFFO checks SFFO offsets against the walked mip offsets and file size.
+func validateSFFO(h *fileHeader, mipOffsets []int64, size int64) []Issue {
+	sffo, ok := h.tags.Get("SFFO")
+	if !ok && len(h.tags) == 0 && h.typ == PaxP8 {
+		// PAC-style indexed textures carry no tags at all.
+		return nil
+	}
+
+	sffoOffset := int64(-1)
+	for _, t := range h.tags {
+		if t.Name == "SFFO" {
+			sffoOffset = t.Offset
+		}
+	}
+
+	offsets, err := sffoOffsets(map[string][]byte{"SFFO": sffo})
+	if !ok || err != nil {
+		return []Issue{{
+			Kind:    IssueSFFOMissing,
+			Mip:     -1,
+			Tag:     "SFFO",
+			Offset:  sffoOffset,
+			Message: "SFFO tag is missing or has no non-zero offsets",
+		}}
+	}
+
+	var issues []Issue
+	for i, off := range offsets {
+		if int64(off) >= size {
+			issues = append(issues, Issue{
+				Kind:    IssueSFFOPastEOF,
+				Mip:     i,
+				Tag:     "SFFO",
+				Offset:  sffoOffset,
+				Message: fmt.Sprintf("SFFO entry %d points to %d, file size is %d", i, off, size),
+			})
+		}
+		if i > 0 && off <= offsets[i-1] {
+			issues = append(issues, Issue{
+				Kind:    IssueSFFOOrder,
+				Mip:     i,
+				Tag:     "SFFO",
+				Offset:  sffoOffset,
+				Message: fmt.Sprintf("SFFO entry %d (%d) does not follow entry %d (%d)", i, off, i-1, offsets[i-1]),
+			})
+		}
+		if i < len(mipOffsets) && int64(off) != mipOffsets[i] {
+			issues = append(issues, Issue{
+				Kind:    IssueSFFOMismatch,
+				Mip:     i,
+				Tag:     "SFFO",
+				Offset:  sffoOffset,
+				Message: fmt.Sprintf("SFFO entry %d is %d, mip %d starts at %d", i, off, i, mipOffsets[i]),
+			})
+		}
+	}
+
+	if len(offsets) != len(mipOffsets) {
+		issues = append(issues, Issue{
+			Kind:    IssueSFFOMismatch,
+			Mip:     -1,
+			Tag:     "SFFO",
+			Offset:  sffoOffset,
+			Message: fmt.Sprintf("SFFO lists %d mips, file has %d", len(offsets), len(mipOffsets)),
+		})
+	}
+
+	return issues
+}
+
+// validateAverageColor compares CGVA with the average color of mm (swizzle applied).
+// Each channel may match either the plain mean or the gamma 2.2 mean (BI tools
+// average color channels in linear space).
+func validateAverageColor(p *PAA, mm *MipMap) []Issue {
+	cgva, ok := p.tagMap()["CGVA"]
+	if !ok || len(cgva) != 4 {
+		return nil
+	}
+
+	m := *mm
+	m.file = p
+	img, err := m.Image()
+	if err != nil {
+		return []Issue{{Kind: IssueMipDecode, Mip: 0, Offset: -1, Message: fmt.Sprintf("mip 0: %v", err)}}
+	}
+
+	var toLinear [256]float64
+	for i := range toLinear {
+		toLinear[i] = math.Pow(float64(i)/255, 2.2)
+	}
+
+	var sum [4]uint64
+	var linSum [4]float64
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			bgra := [4]uint8{c.B, c.G, c.R, c.A}
+			for i, v := range bgra {
+				sum[i] += uint64(v)
+				linSum[i] += toLinear[v]
+			}
+		}
+	}
+
+	n := uint64(b.Dx()) * uint64(b.Dy()) //nolint:gosec // bounds are non-negative
+	var avg, gammaAvg [4]byte
+	match := true
+	for i := range sum {
+		avg[i] = uint8(sum[i] / n)                                                   //nolint:gosec // G115
+		gammaAvg[i] = uint8(math.Round(255 * math.Pow(linSum[i]/float64(n), 1/2.2))) //nolint:gosec // G115
+		if absDiffByte(avg[i], cgva[i]) > cgvaTolerance && absDiffByte(gammaAvg[i], cgva[i]) > cgvaTolerance {
+			match = false
+		}
+	}
+	if match {
+		return nil
+	}
+
+	return []Issue{{
+		Kind:    IssueAverageColor,
+		Mip:     0,
+		Tag:     "CGVA",
+		Offset:  -1,
+		Message: fmt.Sprintf("CGVA (BGRA) is % x, decoded average is % x (gamma 2.2: % x)", cgva, avg[:], gammaAvg[:]),
+	}}
+}
+
+// isPowerOfTwo reports whether v is a positive power of two.
+func isPowerOfTwo(v int) bool {
+	return v > 0 && v&(v-1) == 0
+}
+
+// absDiffByte returns |a-b|.
+func absDiffByte(a, b byte) byte {
+	if a > b {
+		return a - b
+	}
+
+	return b - a
+}
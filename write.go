@@ -1,15 +1,15 @@
 package paa
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"fmt"
 	"image"
-	"image/color"
 	"io"
 
-	"github.com/woozymasta/bcn"
 	"github.com/woozymasta/lzo"
 	"github.com/woozymasta/lzss"
-	"github.com/woozymasta/paa/texconfig"
 )
 
 // Encode writes the image as PAA with a single mip level using default settings:
@@ -23,77 +23,56 @@ func Encode(w io.Writer, img image.Image) error {
 // If opts is nil, behavior is the same as Encode (auto DXT1/DXT5 by alpha).
 // If opts.NormalMapSwizzle is true, swizzleNormalMap is applied first and format is DXT5 (for _nohq).
 // If opts.Type is set (e.g. PaxDXT1, PaxDXT5), that format is used; otherwise format is chosen by alpha.
+// Non power-of-two images are handled according to opts.PowerOfTwo.
 func EncodeWithOptions(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	statImg := img
-	if opts != nil && opts.NormalMapSwizzle {
-		img = swizzleNormalMap(img)
-	}
-
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	var avgR, avgG, avgB, avgA uint64
-	var maxR, maxG, maxB, maxA uint8
-	hasAlpha := false
-
-	// Calculate AVG and MAX colors.
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := color.NRGBAModel.Convert(statImg.At(x, y)).(color.NRGBA)
-			r8, g8, b8, a8 := c.R, c.G, c.B, c.A
-
-			if a8 < 255 {
-				hasAlpha = true
-			}
+	return EncodeContext(context.Background(), w, img, opts)
+}
 
-			avgR += uint64(r8)
-			avgG += uint64(g8)
-			avgB += uint64(b8)
-			avgA += uint64(a8)
+// EncodeContext is EncodeWithOptions with cancellation and progress reports.
+//
+// ctx is checked before mip generation, between mips and between strips of
+// DXT levels; once it is done, encoding stops and returns ctx.Err() without
+// writing anything to w. Progress goes to opts.Progress (see ProgressFunc).
+// The output is identical to EncodeWithOptions.
+func EncodeContext(ctx context.Context, w io.Writer, img image.Image, opts *EncodeOptions) error {
+	return encodeContext(ctx, w, img, opts, nil)
+}
 
-			if r8 > maxR {
-				maxR = r8
-			}
-			if g8 > maxG {
-				maxG = g8
-			}
-			if b8 > maxB {
-				maxB = b8
-			}
-			if a8 > maxA {
-				maxA = a8
-			}
-		}
+// encodeContext is EncodeContext with the color statistics of img, when the
+// caller has already analyzed it; nil stats are computed here.
+func encodeContext(ctx context.Context, w io.Writer, img image.Image, opts *EncodeOptions, stats *colorStats) error {
+	var progress ProgressFunc
+	if opts != nil {
+		progress = opts.Progress
 	}
-
-	pixelCount := uint64(width) * uint64(height) //nolint:gosec // bounds are non-negative
-	if pixelCount > 0 {
-		avgR /= pixelCount
-		avgG /= pixelCount
-		avgB /= pixelCount
-		avgA /= pixelCount
+	work := newWorkState(ctx, progress)
+	if err := work.err(); err != nil {
+		return err
 	}
 
-	var paxType PaxType
-	if opts != nil && opts.Type != 0 {
-		paxType = opts.Type
-	} else if opts != nil && opts.NormalMapSwizzle {
-		paxType = PaxDXT5
-	} else if hasAlpha {
-		paxType = PaxDXT5
-	} else {
-		paxType = PaxDXT1
+	if opts != nil {
+		size := img.Bounds().Size()
+		var err error
+		if img, err = applyPowerOfTwo(img, opts.PowerOfTwo, resampleParams{filter: opts.ResampleFilter, wrap: opts.WrapEdges}); err != nil {
+			return err
+		}
+		// Scaling and padding always change the size.
+		if img.Bounds().Size() != size {
+			stats = nil
+		}
 	}
 
-	var compressedData []byte
-	var err error
+	if stats == nil {
+		s := colorStatsOf(img)
+		stats = &s
+	}
+	paxType := encodePaxType(opts, stats.hasAlpha)
 
 	// Mipmap options (defaults mimic BI: full chain down to 4x4).
 	generateMips := true
 	maxMipCount := 0
 	minMipSize := 4
-	useSRGB := false
-	filter := texconfig.MipmapFilterDefault
+	gen := mipGenOptions{}
 	if !isDXT(paxType) {
 		minMipSize = 1
 	}
@@ -110,60 +89,30 @@ func EncodeWithOptions(w io.Writer, img image.Image, opts *EncodeOptions) error
 			minMipSize = opts.MinMipSize
 		}
 		if opts.UseSRGB {
-			useSRGB = true
+			gen.useSRGB = true
 		}
 		if opts.MipmapFilter != nil {
-			filter = *opts.MipmapFilter
-		}
-	}
-
-	// BCn encoder options (quality/refinement/workers).
-	var bcnOpts *bcn.EncodeOptions
-	if opts != nil && opts.BCn != nil {
-		bcnOpts = opts.BCn
-	}
-
-	useDXT5 := paxType == PaxDXT5
-	writeGALF := false
-	galfValue := byte(1)
-	writeZIWS := false
-
-	// ZIWS tag is written in canonical order: 0x05, 0x04, 0x02, 0x03 for nohq.
-	var ziwsTag [4]byte
-	if opts != nil {
-		if opts.WriteNohqSwizzleTag {
-			writeZIWS = true
-			ziwsTag = [4]byte{0x05, 0x04, 0x02, 0x03}
+			gen.filter = *opts.MipmapFilter
 		}
-		if opts.WriteSwizzleTag {
-			writeZIWS = true
-			ziwsTag = opts.SwizzleTag
-		}
-		if opts.WriteGALF {
-			writeGALF = true
-			if opts.GALFValue != 0 {
-				galfValue = opts.GALFValue
-			}
-		}
-	} else if useDXT5 {
-		writeGALF = true
-	}
-
-	type mipBlock struct {
-		data  []byte
-		w, h  int
-		useLZ bool
+		gen.kernel = opts.MipKernel
+		gen.wrap = opts.WrapEdges
+		gen.coverageRef = opts.AlphaCoverageRef
 	}
-	mips := make([]mipBlock, 0, 8)
 
 	// Generate mipmaps.
 	// Build mip chain from the original (unswizzled) image, then swizzle per-mip
 	// if required. This keeps CGVA/CXAM consistent with the original content.
-	mipImages := []image.Image{statImg}
+	mipImages := []image.Image{img}
 	if generateMips {
+		work.report(ProgressMipmaps, 0, 0)
 		mipImages = make([]image.Image, 0)
 
-		for _, m := range generateMipmapsWithFilter(statImg, useSRGB, filter) {
+		chain, err := generateMipmapsWithFilter(img, gen, work)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range chain {
 			mipImages = append(mipImages, m)
 			if maxMipCount > 0 && len(mipImages) >= maxMipCount {
 				break
@@ -174,191 +123,511 @@ func EncodeWithOptions(w io.Writer, img image.Image, opts *EncodeOptions) error
 				break
 			}
 		}
+
+		work.report(ProgressMipmaps, len(mipImages)-1, 1)
 	}
 
-	for _, m := range mipImages {
-		encodeImg := m
-		if opts != nil && opts.NormalMapSwizzle {
-			encodeImg = swizzleNormalMap(m)
+	return encodeMips(w, mipImages, paxType, *stats, opts, work)
+}
+
+// EncodeMipChain writes a PAA from caller-supplied mip levels, largest first,
+// instead of generating them from the base image.
+//
+// Each level must halve the previous one (dimensions round down and stop at 1),
+// otherwise ErrInvalidMipChain is returned. CGVA/CXAM and the automatic pax
+// type come from mips[0]; swizzle, LZO/LZSS and tags follow opts exactly as in
+// EncodeWithOptions. Mip generation options (GenerateMipmaps, MaxMipCount,
+// MinMipSize, MipmapFilter, MipKernel, UseSRGB, AlphaCoverageRef) are
+// ignored. Levels cannot be resized, so any PowerOfTwo policy other than allow
+// rejects a non power-of-two chain. opts.Progress gets ProgressEncode reports
+// as in EncodeWithOptions.
+func EncodeMipChain(w io.Writer, mips []image.Image, opts *EncodeOptions) error {
+	if err := checkMipChain(mips); err != nil {
+		return err
+	}
+	if opts != nil {
+		if err := checkPowerOfTwo(mips[0].Bounds().Size(), opts.PowerOfTwo); err != nil {
+			return err
+		}
+	}
+
+	var progress ProgressFunc
+	if opts != nil {
+		progress = opts.Progress
+	}
+
+	stats := colorStatsOf(mips[0])
+	work := newWorkState(context.Background(), progress)
+	return encodeMips(w, mips, encodePaxType(opts, stats.hasAlpha), stats, opts, work)
+}
+
+// EncodePrecompressed writes a PAA from DXT block data produced elsewhere
+// (another compressor or a cache), without decoding or recompressing it.
+//
+// Each mip holds uncompressed BC1 (DXT1) or BC2/BC3 blocks in Data; all mips
+// must share one DXT Type, match its block size and halve like in
+// EncodeMipChain. Tags, SFFO and per-mip LZO follow opts as in
+// EncodeWithOptions; CGVA/CXAM are computed from the decoded first level with
+// the written ZIWS swizzle undone. Pixel options (Swizzle, NormalMapSwizzle,
+// BCn, mip generation) are ignored: blocks are written as given. As in
+// EncodeMipChain, a PowerOfTwo policy other than allow rejects non-POT sizes.
+func EncodePrecompressed(w io.Writer, mips []*MipMap, opts *EncodeOptions) error {
+	if len(mips) == 0 {
+		return ErrNoMipmaps
+	}
+
+	paxType := mips[0].Type
+	if !isDXT(paxType) {
+		return fmt.Errorf("%w: %v is not a DXT type", ErrUnsupportedFormat, paxType)
+	}
+
+	sizes := make([]image.Point, len(mips))
+	for i, mm := range mips {
+		if mm.Type != paxType {
+			return fmt.Errorf("%w: mip %d is %v, mip 0 is %v", ErrUnsupportedFormat, i, mm.Type, paxType)
 		}
-		if opts != nil && opts.Swizzle != nil && !opts.SkipSwizzle {
-			encodeImg = texconfig.ApplyChannelSwizzle(encodeImg, *opts.Swizzle)
+
+		want := expectedMipSize(paxType, int(mm.Width), int(mm.Height))
+		if len(mm.Data) != want {
+			return fmt.Errorf("%w: mip %d has %d bytes, %dx%d %v needs %d",
+				ErrInsufficientData, i, len(mm.Data), mm.Width, mm.Height, paxType, want)
 		}
 
-		if isDXT(paxType) {
-			if paxType == PaxDXT5 {
-				compressedData, _, _, err = bcn.EncodeImageWithOptions(encodeImg, bcn.FormatDXT5, bcnOpts)
-			} else {
-				compressedData, _, _, err = bcn.EncodeImageWithOptions(encodeImg, bcn.FormatDXT1, bcnOpts)
-			}
-			if err != nil {
-				return err
-			}
-		} else {
-			compressedData, err = encodePixelFormat(paxType, encodeImg)
-			if err != nil {
-				return err
-			}
+		sizes[i] = image.Pt(int(mm.Width), int(mm.Height))
+	}
+	if err := checkMipSizes(sizes); err != nil {
+		return err
+	}
+	if opts != nil {
+		if err := checkPowerOfTwo(sizes[0], opts.PowerOfTwo); err != nil {
+			return err
 		}
+	}
 
-		// Per-mip LZO: DXT only, use only if it reduces size.
-		useLZO := opts != nil && opts.UseLZO && isDXT(paxType)
-		useLZ := false
-		if useLZO {
-			comp, cerr := lzo.Compress(compressedData, nil)
-			if cerr != nil {
-				return cerr
-			}
-			if len(comp) < len(compressedData) {
-				compressedData = comp
-				useLZ = true
-			}
+	base := *mips[0]
+	base.file = &PAA{Taggs: map[string][]byte{"ZIWS": encodeSwizzleTag(opts)}}
+	img, err := base.Image()
+	if err != nil {
+		return err
+	}
+
+	blocks := make([]mipBlock, 0, len(mips))
+	for _, mm := range mips {
+		mb, err := storeMip(mm.Data, int(mm.Width), int(mm.Height), paxType, opts)
+		if err != nil {
+			return err
 		}
-		// Non-DXT LZSS: used by BI tools; apply if it reduces size.
-		if !isDXT(paxType) {
-			comp, cerr := lzss.Compress(compressedData, &lzss.CompressOptions{
-				Checksum:    lzss.ChecksumSigned,
-				SearchLimit: 2048,
-			})
-			if cerr != nil {
-				return cerr
-			}
 
-			forceLZSS := opts != nil && opts.ForceLZSS
-			if forceLZSS || len(comp) < len(compressedData) {
-				compressedData = comp
-			}
+		blocks = append(blocks, mb)
+	}
+
+	_, err = writeFile(w, paxType, encodeTags(paxType, colorStatsOf(img), opts), nil, blocks)
+	return err
+}
+
+// checkMipChain verifies that every image level halves the previous one.
+func checkMipChain(mips []image.Image) error {
+	sizes := make([]image.Point, len(mips))
+	for i, m := range mips {
+		sizes[i] = m.Bounds().Size()
+	}
+
+	return checkMipSizes(sizes)
+}
+
+// checkMipSizes verifies that every level size halves the previous one.
+func checkMipSizes(sizes []image.Point) error {
+	if len(sizes) == 0 {
+		return ErrNoMipmaps
+	}
+	if len(sizes) > maxSFFOEntries {
+		return fmt.Errorf("%w: %d levels, SFFO holds %d", ErrInvalidMipChain, len(sizes), maxSFFOEntries)
+	}
+
+	w, h := sizes[0].X, sizes[0].Y
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("%w: level 0 is %dx%d", ErrInvalidMipChain, w, h)
+	}
+
+	for i := 1; i < len(sizes); i++ {
+		w, h = max(w/2, 1), max(h/2, 1)
+		if sizes[i].X != w || sizes[i].Y != h {
+			return fmt.Errorf("%w: level %d is %dx%d, want %dx%d", ErrInvalidMipChain, i, sizes[i].X, sizes[i].Y, w, h)
 		}
+	}
+
+	return nil
+}
+
+// colorStats holds per-channel average and maximum (RGBA order) of a level.
+type colorStats struct {
+	avg, max [4]uint8
+	hasAlpha bool
+	// dynRange marks max as the CXAM scale of an expanded texture; it is
+	// written as is regardless of ForceCXAMFull.
+	dynRange bool
+}
+
+// colorStatsOf calculates AVG and MAX colors of img for the CGVA/CXAM tags.
+func colorStatsOf(img image.Image) colorStats {
+	return analyzeImage(img).colors
+}
 
-		b := encodeImg.Bounds()
-		mips = append(mips, mipBlock{
-			w:     b.Dx(),
-			h:     b.Dy(),
-			data:  compressedData,
-			useLZ: useLZ,
-		})
+// encodePaxType picks the pax type: opts.Type, DXT5 for normal maps and alpha, else DXT1.
+func encodePaxType(opts *EncodeOptions, hasAlpha bool) PaxType {
+	switch {
+	case opts != nil && opts.Type != 0:
+		return opts.Type
+	case opts != nil && opts.NormalMapSwizzle:
+		return PaxDXT5
+	case hasAlpha:
+		return PaxDXT5
+	default:
+		return PaxDXT1
 	}
+}
 
-	// Write PaxType as first tag.
-	if _, err := w.Write(paxType.Bytes()); err != nil {
+// encodeMips swizzles, compresses and writes mipImages with tags derived from stats.
+// Levels are encoded concurrently (see encodeLevels); nothing is written when
+// work is cancelled.
+func encodeMips(w io.Writer, mipImages []image.Image, paxType PaxType, stats colorStats, opts *EncodeOptions, work *workState) error {
+	if opts != nil && opts.ExpandDynRange {
+		mipImages, stats = expandMips(mipImages, stats)
+	}
+
+	e := &mipEncoder{
+		opts:    opts,
+		prog:    &stageProgress{work: work, stage: ProgressEncode},
+		paxType: paxType,
+	}
+	// BCn encoder options (quality/refinement/workers).
+	if opts != nil && opts.BCn != nil {
+		e.bcnOpts = opts.BCn
+	}
+	for _, m := range mipImages {
+		e.prog.total += m.Bounds().Dx() * m.Bounds().Dy()
+	}
+
+	// Indexed textures share one palette, built from the base level.
+	if paxType == PaxP8 {
+		e.palette = quantizePalette(e.prepare(mipImages[0]), maxPaletteColors)
+	}
+
+	mips, err := e.encodeLevels(mipImages)
+	if err != nil {
 		return err
 	}
 
-	// Write tag in canonical order: GGAT, NAME, LEN, DATA.
-	writeTag := func(name string, payload []byte) error {
-		if _, err := w.Write([]byte("GGAT")); err != nil {
-			return err
+	var rawPalette []byte
+	if e.palette != nil {
+		rawPalette = paletteBytes(e.palette)
+	}
+
+	_, err = writeFile(w, paxType, encodeTags(paxType, stats, opts), rawPalette, mips)
+	return err
+}
+
+// expandMips stretches the color range of every level by the maxima of the
+// base level (see EncodeOptions.ExpandDynRange). CGVA is taken from the
+// expanded base so that it matches the stored texels, like for other textures.
+func expandMips(mipImages []image.Image, stats colorStats) ([]image.Image, colorStats) {
+	cxam := dynRangeMax(stats.max)
+	out := make([]image.Image, len(mipImages))
+	for i, m := range mipImages {
+		out[i] = expandDynRange(m, cxam)
+	}
+
+	stats.avg = colorStatsOf(out[0]).avg
+	stats.max = cxam
+	stats.dynRange = true
+
+	return out, stats
+}
+
+// encodeSwizzleTag returns the ZIWS payload requested by opts, nil for none.
+// ZIWS tag is written in canonical order: 0x05, 0x04, 0x02, 0x03 for nohq.
+func encodeSwizzleTag(opts *EncodeOptions) []byte {
+	switch {
+	case opts == nil:
+		return nil
+	case opts.WriteSwizzleTag:
+		return bytes.Clone(opts.SwizzleTag[:])
+	case opts.WriteNohqSwizzleTag:
+		return bytes.Clone(swizzleDXT5NM[:])
+	default:
+		return nil
+	}
+}
+
+// storeMip compresses one encoded mip payload the way BI tools store it.
+func storeMip(payload []byte, w, h int, paxType PaxType, opts *EncodeOptions) (mipBlock, error) {
+	// Per-mip LZO: DXT only, use only if it reduces size.
+	useLZ := false
+	if opts != nil && opts.UseLZO && isDXT(paxType) {
+		comp, ok, err := compressLZO(payload)
+		if err != nil {
+			return mipBlock{}, err
 		}
-		if _, err := w.Write([]byte(name)); err != nil {
-			return err
+		if ok {
+			payload = comp
+			useLZ = true
 		}
-		if err := binary.Write(w, binary.LittleEndian, uint32(len(payload))); err != nil { //nolint:gosec // G115
-			return err
+	}
+
+	// Non-DXT LZSS: used by BI tools; apply if it reduces size.
+	if !isDXT(paxType) {
+		comp, err := compressLZSS(payload)
+		if err != nil {
+			return mipBlock{}, err
 		}
-		if _, err := w.Write(payload); err != nil {
-			return err
+
+		forceLZSS := opts != nil && opts.ForceLZSS
+		if forceLZSS || len(comp) < len(payload) {
+			payload = comp
 		}
-		return nil
 	}
 
+	return mipBlock{w: w, h: h, data: payload, useLZ: useLZ}, nil
+}
+
+// encodeTags returns the tags to write in canonical order:
+// CGVA, CXAM, GALF (optional), ZIWS (optional), extra tags, SFFO.
+func encodeTags(paxType PaxType, stats colorStats, opts *EncodeOptions) []tagEntry {
+	alphaDXT := isDXT(paxType) && paxType != PaxDXT1
+	writeGALF := false
+	galfValue := byte(AlphaInterpolated)
+	if opts != nil {
+		if opts.WriteGALF {
+			writeGALF = true
+			if opts.GALFValue != 0 {
+				galfValue = opts.GALFValue
+			}
+		}
+	} else if alphaDXT {
+		writeGALF = true
+	}
+
+	avg, mx := stats.avg, stats.max
 	if opts != nil && opts.NormalMapSwizzle {
-		maxR, maxG, maxB, maxA = 255, 255, 255, 255
+		mx = [4]uint8{255, 255, 255, 255}
 	}
 
 	// BI tools appear to write CXAM as full 0xFF for DXT textures, regardless of actual max.
 	// This affects viewer stats but not pixel payload.
 	if (opts != nil && opts.ForceCXAMFull) || (opts == nil && isDXT(paxType)) {
-		maxR, maxG, maxB, maxA = 255, 255, 255, 255
+		mx = [4]uint8{255, 255, 255, 255}
 	}
 
-	if err := writeTag("CGVA", []byte{uint8(avgB), uint8(avgG), uint8(avgR), uint8(avgA)}); err != nil { //nolint:gosec // G115
-		return err
-	}
-	if err := writeTag("CXAM", []byte{maxB, maxG, maxR, maxA}); err != nil {
-		return err
+	if stats.dynRange {
+		mx = stats.max
 	}
 
-	// Write optional GALF and ZIWS tags.
+	tags := []tagEntry{
+		{name: "CGVA", data: []byte{avg[2], avg[1], avg[0], avg[3]}},
+		{name: "CXAM", data: []byte{mx[2], mx[1], mx[0], mx[3]}},
+	}
 	if writeGALF {
-		if err := writeTag("GALF", []byte{galfValue, 0, 0, 0}); err != nil {
-			return err
-		}
+		tags = append(tags, tagEntry{name: "GALF", data: []byte{galfValue, 0, 0, 0}})
 	}
-	if writeZIWS {
-		if err := writeTag("ZIWS", ziwsTag[:]); err != nil {
-			return err
+	if ziws := encodeSwizzleTag(opts); ziws != nil {
+		tags = append(tags, tagEntry{name: "ZIWS", data: ziws})
+	}
+	if opts != nil {
+		tags = appendExtraTags(tags, opts.ExtraTags)
+	}
+
+	return append(tags, tagEntry{name: "SFFO"})
+}
+
+// mipBlock is one mip level ready to be written: stored payload, dimensions and LZO flag.
+type mipBlock struct {
+	data  []byte
+	w, h  int
+	useLZ bool
+}
+
+// tagEntry is one GGAT tag to be written. SFFO payload is computed by writeFile.
+type tagEntry struct {
+	name string
+	data []byte
+}
+
+// appendExtraTags adds caller-supplied tags: the first tag with the name of an
+// already generated tag replaces its payload, all others are appended in order,
+// duplicates included. SFFO is ignored.
+func appendExtraTags(tags []tagEntry, extra []Tag) []tagEntry {
+	replaced := make([]bool, len(tags))
+	for _, t := range extra {
+		if t.Name == "SFFO" {
+			continue
+		}
+
+		i := 0
+		for ; i < len(replaced); i++ {
+			if tags[i].name == t.Name && !replaced[i] {
+				tags[i].data = t.Data
+				replaced[i] = true
+				break
+			}
+		}
+		if i == len(replaced) {
+			tags = append(tags, tagEntry{name: t.Name, data: t.Data})
 		}
 	}
 
-	// Calculate SFFO offset.
-	offset := 2 + 16 + 16
-	if writeGALF {
-		offset += 16
+	return tags
+}
+
+// compressLZO compresses a DXT payload with LZO and reports whether it reduced size.
+func compressLZO(raw []byte) ([]byte, bool, error) {
+	comp, err := lzo.Compress(raw, nil)
+	if err != nil {
+		return nil, false, err
 	}
-	if writeZIWS {
-		offset += 16
+
+	if len(comp) >= len(raw) {
+		return raw, false, nil
 	}
-	offset += 76 + 2
-	baseOffset := uint32(offset) //nolint:gosec // G115
+
+	return comp, true, nil
+}
+
+// compressLZSS compresses a non-DXT payload with LZSS (signed checksum, BI settings).
+func compressLZSS(raw []byte) ([]byte, error) {
+	return lzss.Compress(raw, &lzss.CompressOptions{
+		Checksum:    lzss.ChecksumSigned,
+		SearchLimit: 2048,
+	})
+}
+
+// writeFile writes pax magic, GGAT tags, palette and mip blocks, followed by the terminator.
+// The SFFO entry in tags (if any) is replaced with offsets computed from the mip blocks.
+func writeFile(w io.Writer, paxType PaxType, tags []tagEntry, palette []byte, mips []mipBlock) (int64, error) {
+	cw := &countingWriter{w: w}
+
+	// Calculate first mip offset: magic, tags, palette size and palette triplets.
+	offset := len(paxType.Bytes())
+	for _, t := range tags {
+		if t.name == "SFFO" {
+			offset += 12 + 64
+			continue
+		}
+		offset += 12 + len(t.data)
+	}
+	offset += 2 + len(palette)
 
 	sffo := make([]byte, 64)
 	// Fill offsets for each mip (max 16 entries), relative to file start.
-	off := int(baseOffset)
+	off := offset
 	for i := 0; i < len(mips) && i < 16; i++ {
 		binary.LittleEndian.PutUint32(sffo[i*4:i*4+4], uint32(off)) //nolint:gosec // G115
 		off += 2 + 2 + 3 + len(mips[i].data)
 	}
-	if err := writeTag("SFFO", sffo); err != nil {
-		return err
+
+	// Write PaxType as first tag.
+	if _, err := cw.Write(paxType.Bytes()); err != nil {
+		return cw.n, err
 	}
 
-	if _, err := w.Write([]byte{0, 0}); err != nil {
-		return err
+	for _, t := range tags {
+		payload := t.data
+		if t.name == "SFFO" {
+			payload = sffo
+		}
+		if err := writeTag(cw, t.name, payload); err != nil {
+			return cw.n, err
+		}
+	}
+
+	// Palette: triplet count followed by triplets (empty for non-indexed formats).
+	nColors := len(palette) / 3
+	if err := binary.Write(cw, binary.LittleEndian, uint16(nColors)); err != nil { //nolint:gosec // G115
+		return cw.n, err
+	}
+	if _, err := cw.Write(palette); err != nil {
+		return cw.n, err
 	}
 
 	for _, m := range mips {
-		// LZO is signaled by width's top bit for this mip only.
-		if m.w < 0 || m.h < 0 {
-			return ErrInvalidDimensions
+		if err := writeMipBlock(cw, m); err != nil {
+			return cw.n, err
 		}
+	}
 
-		// Width is stored with LZO flag if used.
-		storedW := m.w
-		if m.useLZ {
-			if m.w > 0x7fff {
-				return ErrInvalidDimensions
-			}
-			storedW = m.w | 0x8000
-		} else if m.w > 0xffff {
-			return ErrInvalidDimensions
-		}
+	// Padding to 64-byte alignment.
+	if _, err := cw.Write([]byte{0, 0, 0, 0, 0, 0}); err != nil {
+		return cw.n, err
+	}
 
-		// Height is always stored as-is, no LZO flag.
-		if m.h > 0xffff {
-			return ErrInvalidDimensions
-		}
-		if err := binary.Write(w, binary.LittleEndian, uint16(storedW)); err != nil { //nolint:gosec // G115
-			return err
-		}
-		if err := binary.Write(w, binary.LittleEndian, uint16(m.h)); err != nil { //nolint:gosec // G115
-			return err
-		}
+	return cw.n, nil
+}
 
-		// Data length is stored as-is, no LZO flag.
-		dLen := len(m.data)
-		if _, err := w.Write([]byte{byte(dLen), byte(dLen >> 8), byte(dLen >> 16)}); err != nil {
-			return err
-		}
+// writeTag writes one GGAT entry: GGAT, NAME, LEN, DATA.
+func writeTag(w io.Writer, name string, payload []byte) error {
+	if _, err := w.Write([]byte("GGAT")); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(name)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(payload))); err != nil { //nolint:gosec // G115
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
 
-		if _, err := w.Write(m.data); err != nil {
-			return err
+	return nil
+}
+
+// writeMipBlock writes one mip block: width (with LZO flag), height, 3-byte size and payload.
+func writeMipBlock(w io.Writer, m mipBlock) error {
+	// LZO is signaled by width's top bit for this mip only.
+	if m.w < 0 || m.h < 0 {
+		return ErrInvalidDimensions
+	}
+
+	// Width is stored with LZO flag if used.
+	storedW := m.w
+	if m.useLZ {
+		if m.w > 0x7fff {
+			return ErrInvalidDimensions
 		}
+		storedW = m.w | 0x8000
+	} else if m.w > 0xffff {
+		return ErrInvalidDimensions
 	}
 
-	// Padding to 64-byte alignment.
-	if _, err := w.Write([]byte{0, 0, 0, 0, 0, 0}); err != nil {
+	// Height is always stored as-is, no LZO flag.
+	if m.h > 0xffff {
+		return ErrInvalidDimensions
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(storedW)); err != nil { //nolint:gosec // G115
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(m.h)); err != nil { //nolint:gosec // G115
 		return err
 	}
 
-	return nil
+	// Data length is stored as-is, no LZO flag.
+	dLen := len(m.data)
+	if _, err := w.Write([]byte{byte(dLen), byte(dLen >> 8), byte(dLen >> 16)}); err != nil {
+		return err
+	}
+
+	_, err := w.Write(m.data)
+	return err
+}
+
+// countingWriter counts bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,138 @@
+package paa
+
+import (
+	"io"
+	"sort"
+)
+
+// canonicalTagOrder lists tags in the order written by BI tools (SFFO is always last).
+var canonicalTagOrder = []string{"CGVA", "CXAM", "GALF", "ZIWS"}
+
+// EncodePAA writes p as a PAA file without re-encoding pixel data.
+// It is a shorthand for p.WriteTo(w).
+func EncodePAA(w io.Writer, p *PAA) error {
+	_, err := p.WriteTo(w)
+	return err
+}
+
+// WriteTo serializes the PAA structure as-is and implements io.WriterTo.
+//
+// Mip payloads (MipMap.Data) are written without touching pixel data. A mip
+// decoded from a compressed block is written back with its stored bytes until
+// its Data is replaced or set with MipMap.SetData, so unmodified files
+// round-trip byte for byte; other mips are compressed again when
+// MipMap.Compressed is set (LZO for DXT, LZSS otherwise), which may not match
+// the original compressor. Tags are written as listed in PAA.Tags (unknown
+// tags and duplicates included); without it, Taggs is written in canonical
+// order (CGVA, CXAM, GALF, ZIWS), followed by any other tags sorted by name.
+// SFFO is always recomputed from the resulting mip layout.
+func (p *PAA) WriteTo(w io.Writer) (int64, error) {
+	if len(p.MipMaps) == 0 {
+		return 0, ErrNoMipmaps
+	}
+	if !isKnownPaxType(p.Type) {
+		return 0, ErrUnsupportedFormat
+	}
+
+	mips := make([]mipBlock, 0, len(p.MipMaps))
+	for _, m := range p.MipMaps {
+		mb, err := storedMipBlock(p.Type, m)
+		if err != nil {
+			return 0, err
+		}
+		mips = append(mips, mb)
+	}
+
+	return writeFile(w, p.Type, p.tagEntries(), p.Palette, mips)
+}
+
+// storedMipBlock prepares a decoded mip for writing, re-applying compression if requested.
+func storedMipBlock(paxType PaxType, m *MipMap) (mipBlock, error) {
+	if m == nil || m.Width == 0 || m.Height == 0 {
+		return mipBlock{}, ErrInvalidDimensions
+	}
+
+	w, h := int(m.Width), int(m.Height)
+	expected := expectedMipSize(paxType, w, h)
+	if expected < 0 {
+		return mipBlock{}, ErrUnsupportedPixelFmt
+	}
+	if len(m.Data) != expected {
+		return mipBlock{}, ErrInsufficientData
+	}
+
+	mb := mipBlock{w: w, h: h, data: m.Data}
+	if !m.Compressed {
+		return mb, nil
+	}
+	if m.storedCurrent() {
+		mb.data = m.stored
+		mb.useLZ = isDXT(paxType)
+		return mb, nil
+	}
+
+	if isDXT(paxType) {
+		// LZO flag lives in the width top bit, so wide mips are stored raw.
+		if w > 0x7fff {
+			return mb, nil
+		}
+
+		comp, ok, err := compressLZO(m.Data)
+		if err != nil {
+			return mipBlock{}, err
+		}
+		if ok {
+			mb.data = comp
+			mb.useLZ = true
+		}
+
+		return mb, nil
+	}
+
+	comp, err := compressLZSS(m.Data)
+	if err != nil {
+		return mipBlock{}, err
+	}
+	// Stored size equal to raw size is read back as uncompressed data.
+	if len(comp) != len(m.Data) {
+		mb.data = comp
+	}
+
+	return mb, nil
+}
+
+// mapTagEntries orders a tag map canonically: CGVA, CXAM, GALF, ZIWS, other tags by name, SFFO.
+func mapTagEntries(tags map[string][]byte) []tagEntry {
+	out := make([]tagEntry, 0, len(tags)+1)
+	for _, name := range canonicalTagOrder {
+		if data, ok := tags[name]; ok {
+			out = append(out, tagEntry{name: name, data: data})
+		}
+	}
+
+	rest := make([]string, 0, len(tags))
+	for name := range tags {
+		if name == "SFFO" || isCanonicalTag(name) {
+			continue
+		}
+		rest = append(rest, name)
+	}
+	sort.Strings(rest)
+
+	for _, name := range rest {
+		out = append(out, tagEntry{name: name, data: tags[name]})
+	}
+
+	return append(out, tagEntry{name: "SFFO"})
+}
+
+// isCanonicalTag reports whether name is one of the tags with a fixed write position.
+func isCanonicalTag(name string) bool {
+	for _, n := range canonicalTagOrder {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}